@@ -0,0 +1,81 @@
+// Command orphans reports devices and network nodes that reference a node
+// which no longer exists, and with -fix unassigns them and validates the
+// foreign keys that keep new ones from appearing.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"equipment-management/internal/config"
+	"equipment-management/internal/repository"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	fix := flag.Bool("fix", false, "unassign orphaned devices, make orphaned nodes roots and validate the foreign keys")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Print("No .env file found")
+	}
+	cfg := config.LoadConfig()
+	if err := repository.InitDB(cfg); err != nil {
+		log.Fatal("Database init error: ", err)
+	}
+
+	devices := repository.NewDeviceRepository(repository.DB)
+	nodes := repository.NewNetworkNodeRepository(repository.DB)
+
+	if !*fix {
+		orphanedDevices, err := devices.FindOrphans()
+		if err != nil {
+			log.Fatal("Failed to find orphaned devices: ", err)
+		}
+		orphanedNodes, err := nodes.FindOrphans()
+		if err != nil {
+			log.Fatal("Failed to find orphaned nodes: ", err)
+		}
+
+		for _, device := range orphanedDevices {
+			fmt.Printf("device %d (serial %s) references missing node %d\n", device.ID, device.Serial, *device.NetworkNodeID)
+		}
+		for _, node := range orphanedNodes {
+			fmt.Printf("node %d (%s) references missing parent %d\n", node.ID, node.Name, *node.ParentID)
+		}
+		fmt.Printf("%d orphaned devices, %d orphaned nodes\n", len(orphanedDevices), len(orphanedNodes))
+		if len(orphanedDevices)+len(orphanedNodes) > 0 {
+			fmt.Println("Run with -fix to repair them.")
+			os.Exit(1)
+		}
+		return
+	}
+
+	actor := repository.Actor{RequestID: "orphans-fix"}
+	fixedDevices, err := devices.DetachOrphans(actor)
+	if err != nil {
+		log.Fatal("Failed to fix orphaned devices: ", err)
+	}
+	fixedNodes, err := nodes.DetachOrphans(actor)
+	if err != nil {
+		log.Fatal("Failed to fix orphaned nodes: ", err)
+	}
+
+	for _, device := range fixedDevices {
+		fmt.Printf("device %d (serial %s) unassigned from missing node %d\n", device.ID, device.Serial, *device.NetworkNodeID)
+	}
+	for _, node := range fixedNodes {
+		fmt.Printf("node %d (%s) detached from missing parent %d\n", node.ID, node.Name, *node.ParentID)
+	}
+	fmt.Printf("%d devices and %d nodes fixed\n", len(fixedDevices), len(fixedNodes))
+
+	if err := repository.MigrateForeignKeys(repository.DB); err != nil {
+		log.Fatal("Failed to migrate foreign keys: ", err)
+	}
+	if err := repository.ValidateForeignKeys(repository.DB); err != nil {
+		log.Fatal("Failed to validate foreign keys: ", err)
+	}
+	fmt.Println("Foreign keys validated")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/rand"
+	"equipment-management/internal/config"
+	"equipment-management/pkg/auth"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// loadKeySet builds the keys tokens are signed and verified with. A private
+// key file takes precedence over JWT_SECRET. Outside of dev mode a missing
+// or weak secret is an error; in dev mode a missing secret is replaced with
+// a random one, which ends the access tokens at every restart.
+func loadKeySet(cfg *config.Config) (*auth.KeySet, error) {
+	var retired []*auth.SigningKey
+	for _, secret := range splitList(cfg.JWTPreviousSecrets) {
+		retired = append(retired, auth.NewHMACKey([]byte(secret)))
+	}
+	for _, path := range splitList(cfg.JWTPublicKeyFiles) {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := auth.ParsePublicKeyPEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		retired = append(retired, key)
+	}
+
+	var current *auth.SigningKey
+	switch {
+	case cfg.JWTPrivateKeyFile != "":
+		data, err := os.ReadFile(cfg.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if current, err = auth.ParsePrivateKeyPEM(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.JWTPrivateKeyFile, err)
+		}
+		// A secret next to a private key stays valid for verification, so
+		// that switching to the key does not end the current tokens.
+		if cfg.JWTSecret != "" {
+			retired = append(retired, auth.NewHMACKey([]byte(cfg.JWTSecret)))
+		}
+
+	case cfg.JWTSecret == "" && cfg.DevMode:
+		secret := make([]byte, auth.MinSecretLength)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		log.Println("JWT_SECRET is not set, using a random secret for this run")
+		current = auth.NewHMACKey(secret)
+
+	case cfg.JWTSecret == "":
+		return nil, errors.New("JWT_SECRET or JWT_PRIVATE_KEY_FILE is required")
+
+	case auth.IsWeakSecret(cfg.JWTSecret):
+		if !cfg.DevMode {
+			return nil, fmt.Errorf("JWT_SECRET is too weak, use a random secret of at least %d characters", auth.MinSecretLength)
+		}
+		log.Println("Warning: JWT_SECRET is weak, which is accepted in dev mode only")
+		current = auth.NewHMACKey([]byte(cfg.JWTSecret))
+
+	default:
+		current = auth.NewHMACKey([]byte(cfg.JWTSecret))
+	}
+
+	return auth.NewKeySet(cfg.JWTIssuer, cfg.JWTAudience, current, retired...)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -1,22 +1,29 @@
 package main
 
 import (
+	"equipment-management/internal/apperror"
 	"equipment-management/internal/controller"
 	"equipment-management/internal/middleware"
 	"equipment-management/internal/service"
 	"equipment-management/pkg/auth"
+	"equipment-management/pkg/directory"
+	"equipment-management/pkg/oidc"
 	"fmt"
 	"github.com/joho/godotenv"
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"equipment-management/internal/config"
 	"equipment-management/internal/models"
+	"equipment-management/internal/problem"
 	"equipment-management/internal/repository"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
 )
 
 func main() {
@@ -26,16 +33,17 @@ func main() {
 
 	cfg := config.LoadConfig()
 
+	keys, err := loadKeySet(cfg)
+	if err != nil {
+		log.Fatal("Failed to load JWT keys: ", err)
+	}
+
 	if err := repository.InitDB(cfg); err != nil {
 		log.Fatal("Database init error: ", err)
 	}
 
 	db := repository.DB
-	if err := db.AutoMigrate(
-		&models.User{},
-		&models.Device{},
-		&models.NetworkNode{},
-	); err != nil {
+	if err := repository.Migrate(db); err != nil {
 		log.Fatal("Migration failed: ", err)
 	}
 	log.Println("Migration completed")
@@ -90,68 +98,200 @@ func main() {
 		}
 	}
 
-	deviceRepo := repository.NewDeviceRepository(repository.DB)
+	deviceRepo := repository.NewDeviceRepository(db)
 	networkNodeRepo := repository.NewNetworkNodeRepository(db)
-
-	deviceService := service.NewDeviceService(deviceRepo)
+	auditRepo := repository.NewAuditRepository(db)
+	userRepo := repository.NewUserRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	roleRepo := repository.NewRoleRepository(db)
+	loginAttemptRepo := repository.NewLoginAttemptRepository(db)
+	recoveryCodeRepo := repository.NewRecoveryCodeRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+	ssoStateRepo := repository.NewSSOStateRepository(db)
+
+	deviceService := service.NewDeviceService(deviceRepo, networkNodeRepo)
 	networkNodeService := service.NewNetworkNodeService(networkNodeRepo)
+	auditService := service.NewAuditService(auditRepo, deviceService)
+	roleService := service.NewRoleService(roleRepo)
+	userService := service.NewUserService(userRepo, sessionRepo, networkNodeRepo, loginAttemptRepo, roleService)
+	mfaService := service.NewMFAService(userRepo, recoveryCodeRepo, sessionRepo, roleService)
+	var authenticator service.Authenticator = service.NewLocalAuthenticator(userRepo)
+	if cfg.LDAPURL != "" {
+		groupRoles, err := service.ParseGroupRoles(cfg.LDAPGroupRoles)
+		if err != nil {
+			log.Fatal("Invalid LDAP_GROUP_ROLES: ", err)
+		}
+		ldapDirectory := directory.NewLDAP(directory.Config{
+			URL:            cfg.LDAPURL,
+			StartTLS:       cfg.LDAPStartTLS,
+			BindDN:         cfg.LDAPBindDN,
+			BindPassword:   cfg.LDAPBindPassword,
+			BaseDN:         cfg.LDAPBaseDN,
+			UserFilter:     cfg.LDAPUserFilter,
+			GroupAttribute: cfg.LDAPGroupAttribute,
+		})
+		authenticator = service.NewLDAPAuthenticator(ldapDirectory, userRepo, roleService, groupRoles, cfg.LDAPDefaultRole)
+		log.Println("LDAP authentication enabled:", cfg.LDAPURL)
+	}
+	authService := service.NewAuthService(userRepo, sessionRepo, loginAttemptRepo, roleService, mfaService, authenticator, keys)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, userRepo)
+
+	var ssoProvider *oidc.Provider
+	var ssoGroupRoles []service.GroupRole
+	if cfg.OIDCIssuer != "" {
+		groupRoles, err := service.ParseGroupRoles(cfg.OIDCGroupRoles)
+		if err != nil {
+			log.Fatal("Invalid OIDC_GROUP_ROLES: ", err)
+		}
+		ssoGroupRoles = groupRoles
+		ssoProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDCIssuer,
+			ClientID:     cfg.OIDCClientID,
+			ClientSecret: cfg.OIDCClientSecret,
+			RedirectURL:  cfg.OIDCRedirectURL,
+			Scopes:       strings.Fields(cfg.OIDCScopes),
+		})
+		log.Println("Single sign-on enabled:", cfg.OIDCIssuer)
+	}
+	ssoClaims := service.SSOClaims{Login: cfg.OIDCLoginClaim, Groups: cfg.OIDCGroupsClaim}
+	ssoService := service.NewSSOService(ssoProvider, ssoStateRepo, userRepo, authService, roleService, ssoClaims, ssoGroupRoles, cfg.OIDCDefaultRole)
+
+	if err := roleService.EnsureBuiltinRoles(); err != nil {
+		log.Fatal("Failed to create built-in roles: ", err)
+	}
 
 	deviceController := controller.NewDeviceController(deviceService)
-	networkNodeController := controller.NewNetworkNodeController(networkNodeService)
+	networkNodeController := controller.NewNetworkNodeController(networkNodeService, roleService)
+	auditController := controller.NewAuditController(auditService)
+	userController := controller.NewUserController(userService)
+	authController := controller.NewAuthController(authService)
+	roleController := controller.NewRoleController(roleService)
+	mfaController := controller.NewMFAController(mfaService)
+	apiKeyController := controller.NewAPIKeyController(apiKeyService)
+	ssoController := controller.NewSSOController(ssoService)
+
+	can := func(permission string) gin.HandlerFunc {
+		return middleware.PermissionMiddleware(roleService, permission)
+	}
 
 	r := gin.Default()
+	// The client IP is throttled and recorded with every login attempt, so
+	// it is only taken from X-Forwarded-For when a known proxy sent it.
+	if err := r.SetTrustedProxies(splitList(cfg.TrustedProxies)); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES: ", err)
+	}
+	if validate, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		apperror.JSONFieldNames(validate)
+	}
+	r.NoRoute(func(c *gin.Context) {
+		problem.Respond(c, http.StatusNotFound, "Route not found")
+	})
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:63342", "http://localhost:5500", "http://localhost:8080", "http://localhost"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "If-Match", middleware.APIKeyHeader, middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", "Content-Disposition", "Retry-After", "ETag", middleware.RequestIDHeader},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	r.Use(middleware.RequestIDMiddleware())
+
 	r.Use(func(c *gin.Context) {
-		log.Printf("Запрос: %s %s\n", c.Request.Method, c.Request.URL)
+		log.Printf("Запрос: %s %s [%s]\n", c.Request.Method, c.Request.URL, c.GetString("requestID"))
 		c.Next()
 	})
 
-	r.POST("/login", controller.Login)
+	r.POST("/login", authController.Login)
+	r.POST("/login/mfa", authController.CompleteMFA)
+	r.POST("/login/mfa/enroll", authController.EnrollMFA)
+	r.POST("/login/sso", ssoController.Start)
+	r.POST("/login/sso/callback", ssoController.Callback)
+	r.POST("/token/refresh", authController.Refresh)
 
 	authGroup := r.Group("/")
-	authGroup.Use(middleware.AuthMiddleware())
+	authGroup.Use(middleware.AuthMiddleware(authService, apiKeyService))
 	{
+		authGroup.POST("/logout", authController.Logout)
+
 		authGroup.GET("/protected", func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "You are authenticated!"})
 		})
 
 		deviceGroup := authGroup.Group("/devices")
 		{
-			deviceGroup.GET("", deviceController.GetAllDevices)
-			deviceGroup.GET("/:id", deviceController.GetDevice)
-
-			adminDeviceGroup := deviceGroup.Group("")
-			adminDeviceGroup.Use(middleware.RoleMiddleware("admin"))
-			{
-				adminDeviceGroup.POST("", deviceController.CreateDevice)
-				adminDeviceGroup.PUT("/:id", deviceController.UpdateDevice)
-				adminDeviceGroup.DELETE("/:id", deviceController.DeleteDevice)
-			}
+			deviceGroup.GET("", can(models.PermDeviceRead), deviceController.GetAllDevices)
+			deviceGroup.GET("/export", can(models.PermDeviceRead), deviceController.ExportDevices)
+			deviceGroup.GET("/:id", can(models.PermDeviceRead), deviceController.GetDevice)
+			deviceGroup.GET("/:id/transitions", can(models.PermDeviceRead), deviceController.GetTransitions)
+			deviceGroup.GET("/:id/history", can(models.PermDeviceRead), auditController.GetDeviceHistory)
+			deviceGroup.POST("", can(models.PermDeviceCreate), deviceController.CreateDevice)
+			deviceGroup.POST("/import", can(models.PermDeviceCreate), deviceController.ImportDevices)
+			deviceGroup.PUT("/:id", can(models.PermDeviceUpdate), deviceController.UpdateDevice)
+			deviceGroup.PATCH("/:id", can(models.PermDeviceUpdate), deviceController.PatchDevice)
+			deviceGroup.DELETE("/:id", can(models.PermDeviceDelete), deviceController.DeleteDevice)
+			deviceGroup.POST("/:id/transitions", can(models.PermDeviceTransition), deviceController.TransitionDevice)
 		}
 
 		nodeGroup := authGroup.Group("/network-nodes")
 		{
-			nodeGroup.GET("/tree", networkNodeController.GetFullTree)
-			nodeGroup.GET("", networkNodeController.GetAllNodes)
-			nodeGroup.GET("/:id", networkNodeController.GetNode)
-
-			adminNodeGroup := nodeGroup.Group("")
-			adminNodeGroup.Use(middleware.RoleMiddleware("admin"))
-			{
-				adminNodeGroup.POST("", networkNodeController.CreateNode)
-				adminNodeGroup.PUT("/:id", networkNodeController.UpdateNode)
-				adminNodeGroup.DELETE("/:id", networkNodeController.DeleteNode)
-			}
+			nodeGroup.GET("/tree", can(models.PermNodeRead), networkNodeController.GetFullTree)
+			nodeGroup.GET("", can(models.PermNodeRead), networkNodeController.GetAllNodes)
+			nodeGroup.GET("/:id", can(models.PermNodeRead), networkNodeController.GetNode)
+			nodeGroup.GET("/:id/subtree", can(models.PermNodeRead), networkNodeController.GetSubtree)
+			nodeGroup.GET("/:id/ancestors", can(models.PermNodeRead), networkNodeController.GetAncestors)
+			nodeGroup.GET("/:id/delete-preview", can(models.PermNodeDelete), networkNodeController.PreviewDeleteNode)
+			nodeGroup.POST("", can(models.PermNodeCreate), networkNodeController.CreateNode)
+			nodeGroup.PUT("/order", can(models.PermNodeUpdate), networkNodeController.ReorderNodes)
+			nodeGroup.PUT("/:id", can(models.PermNodeUpdate), networkNodeController.UpdateNode)
+			nodeGroup.PATCH("/:id", can(models.PermNodeUpdate), networkNodeController.PatchNode)
+			nodeGroup.POST("/:id/move", can(models.PermNodeUpdate), networkNodeController.MoveNode)
+			nodeGroup.POST("/:id/copy", can(models.PermNodeCreate), networkNodeController.CopyNode)
+			nodeGroup.DELETE("/:id", can(models.PermNodeDelete), networkNodeController.DeleteNode)
+		}
+
+		authGroup.GET("/audit", can(models.PermAuditRead), auditController.GetEvents)
+		authGroup.GET("/login-attempts", can(models.PermAuditRead), authController.GetLoginAttempts)
+
+		authGroup.GET("/me", userController.GetCurrentUser)
+		authGroup.PUT("/me/password", userController.ChangeOwnPassword)
+		authGroup.GET("/me/mfa", mfaController.GetStatus)
+		authGroup.POST("/me/mfa/totp", mfaController.StartEnrollment)
+		authGroup.POST("/me/mfa/totp/confirm", mfaController.ConfirmEnrollment)
+		authGroup.DELETE("/me/mfa/totp", mfaController.Disable)
+		authGroup.POST("/me/mfa/recovery-codes", mfaController.RegenerateRecoveryCodes)
+
+		userGroup := authGroup.Group("/users")
+		userGroup.Use(can(models.PermUserManage))
+		{
+			userGroup.GET("", userController.GetAllUsers)
+			userGroup.GET("/:id", userController.GetUser)
+			userGroup.POST("", userController.CreateUser)
+			userGroup.PUT("/:id", userController.UpdateUser)
+			userGroup.DELETE("/:id", userController.DeleteUser)
+			userGroup.POST("/:id/disable", userController.DisableUser)
+			userGroup.POST("/:id/enable", userController.EnableUser)
+			userGroup.POST("/:id/password", userController.ResetPassword)
+			userGroup.POST("/:id/logout", userController.RevokeSessions)
+			userGroup.POST("/:id/unlock", userController.Unlock)
+			userGroup.POST("/:id/mfa/reset", mfaController.Reset)
+			userGroup.GET("/:id/api-keys", apiKeyController.GetKeys)
+			userGroup.POST("/:id/api-keys", apiKeyController.CreateKey)
+			userGroup.DELETE("/:id/api-keys/:keyId", apiKeyController.RevokeKey)
+		}
+
+		roleGroup := authGroup.Group("/roles")
+		roleGroup.Use(can(models.PermRoleManage))
+		{
+			roleGroup.GET("", roleController.GetAllRoles)
+			roleGroup.GET("/:id", roleController.GetRole)
+			roleGroup.POST("", roleController.CreateRole)
+			roleGroup.PUT("/:id", roleController.UpdateRole)
+			roleGroup.PUT("/:id/mfa", roleController.SetRequireMFA)
+			roleGroup.DELETE("/:id", roleController.DeleteRole)
 		}
+		authGroup.GET("/permissions", can(models.PermRoleManage), roleController.GetPermissions)
 	}
 
 	log.Printf("Server starting on :%s...\n", cfg.ServerPort)
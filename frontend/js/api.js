@@ -1,6 +1,33 @@
 const API_BASE_URL = 'http://localhost:8080';
 
-async function fetchWithAuth(url, options = {}) {
+let refreshPromise = null;
+
+async function refreshTokens() {
+    const refreshToken = localStorage.getItem('refreshToken');
+    if (!refreshToken) {
+        return false;
+    }
+
+    const response = await fetch(`${API_BASE_URL}/token/refresh`, {
+        method: 'POST',
+        headers: {'Content-Type': 'application/json'},
+        body: JSON.stringify({refresh_token: refreshToken})
+    });
+    if (!response.ok) {
+        localStorage.removeItem('authToken');
+        localStorage.removeItem('refreshToken');
+        return false;
+    }
+
+    const {token, refresh_token, role, permissions} = await response.json();
+    localStorage.setItem('authToken', token);
+    localStorage.setItem('refreshToken', refresh_token);
+    localStorage.setItem('userRole', role);
+    localStorage.setItem('permissions', JSON.stringify(permissions || []));
+    return true;
+}
+
+async function fetchWithAuth(url, options = {}, retried = false) {
     const token = localStorage.getItem('authToken');
 
     const headers = {
@@ -17,6 +44,16 @@ async function fetchWithAuth(url, options = {}) {
         headers
     });
 
+    if (response.status === 401 && !retried) {
+        refreshPromise = refreshPromise || refreshTokens().finally(() => {
+            refreshPromise = null;
+        });
+        if (await refreshPromise) {
+            return fetchWithAuth(url, options, true);
+        }
+        window.location.href = 'index.html';
+    }
+
     if (response.status === 204) {
         return null;
     }
@@ -25,10 +62,7 @@ async function fetchWithAuth(url, options = {}) {
         let errorMsg = 'Ошибка запроса';
 
         try {
-            const errorBody = await response.json();
-            if (errorBody.error) {
-                errorMsg = errorBody.error;
-            }
+            errorMsg = problemMessage(await response.json(), errorMsg);
         } catch (e) {
             const text = await response.text();
             if (text) {
@@ -48,33 +82,57 @@ async function fetchWithAuth(url, options = {}) {
 }
 
 window.api = {
+    logout: () => fetchWithAuth('/logout', {method: 'POST'}, true),
+
     getFullTree: () => fetchWithAuth('/network-nodes/tree'),
 
     getAllNodes: () => fetchWithAuth('/network-nodes'),
     getNode: (id) => fetchWithAuth(`/network-nodes/${id}`),
+    getSubtree: (id, depth) => fetchWithAuth(
+        depth === undefined ? `/network-nodes/${id}/subtree` : `/network-nodes/${id}/subtree?depth=${depth}`
+    ),
+    getAncestors: (id) => fetchWithAuth(`/network-nodes/${id}/ancestors`),
     createNode: (data) => fetchWithAuth('/network-nodes', {
         method: 'POST',
         body: JSON.stringify(data)
     }),
-    updateNode: (id, data) => fetchWithAuth(`/network-nodes/${id}`, {
+    updateNode: (id, data, version) => fetchWithAuth(`/network-nodes/${id}`, {
         method: 'PUT',
+        headers: {'If-Match': `"${version}"`},
         body: JSON.stringify(data)
     }),
     deleteNode: (id) => fetchWithAuth(`/network-nodes/${id}`, {
         method: 'DELETE'
     }),
 
-    getAllDevices: () => fetchWithAuth('/devices'),
+    getDevices: (params = {}) => {
+        const query = new URLSearchParams(
+            Object.entries(params).filter(([, value]) => value !== undefined && value !== null && value !== '')
+        ).toString();
+        return fetchWithAuth(query ? `/devices?${query}` : '/devices');
+    },
     getDevice: (id) => fetchWithAuth(`/devices/${id}`),
     createDevice: (data) => fetchWithAuth('/devices', {
         method: 'POST',
         body: JSON.stringify(data)
     }),
-    updateDevice: (id, data) => fetchWithAuth(`/devices/${id}`, {
+    updateDevice: (id, data, version) => fetchWithAuth(`/devices/${id}`, {
         method: 'PUT',
+        headers: {'If-Match': `"${version}"`},
         body: JSON.stringify(data)
     }),
     deleteDevice: (id) => fetchWithAuth(`/devices/${id}`, {
         method: 'DELETE'
+    }),
+    importDevices: (csvText, dryRun = false) => fetchWithAuth(`/devices/import?dry_run=${dryRun}`, {
+        method: 'POST',
+        headers: {'Content-Type': 'text/csv'},
+        body: csvText
+    }),
+    getDeviceHistory: (id) => fetchWithAuth(`/devices/${id}/history`),
+    getDeviceTransitions: (id) => fetchWithAuth(`/devices/${id}/transitions`),
+    transitionDevice: (id, status, reason) => fetchWithAuth(`/devices/${id}/transitions`, {
+        method: 'POST',
+        body: JSON.stringify({status, reason})
     })
 };
\ No newline at end of file
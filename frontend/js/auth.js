@@ -11,35 +11,49 @@ document.addEventListener('DOMContentLoaded', () => {
             const password = document.getElementById('password').value;
 
             try {
-                const response = await fetch(`http://localhost:8080/login`, {
-                    method: 'POST',
-                    headers: {
-                        'Content-Type': 'application/json'
-                    },
-                    body: JSON.stringify({ login, password })
-                });
-
-                if (!response.ok) {
-                    const error = await response.json();
-                    throw new Error(error.error || 'Ошибка авторизации');
-                }
-
-                const { token, role } = await response.json();
-                localStorage.setItem('authToken', token);
-                localStorage.setItem('userRole', role);
-
-                window.location.href = 'dashboard.html';
+                await finishLogin(await postLogin('login', { login, password }));
             } catch (error) {
-                errorMessage.textContent = error.message;
-                errorMessage.style.display = 'block';
+                showError(errorMessage, error);
             }
         });
     }
 
+    const ssoBtn = document.getElementById('sso-btn');
+    if (ssoBtn) {
+        ssoBtn.addEventListener('click', async () => {
+            try {
+                const { authorization_url } = await postLogin('login/sso', {});
+                window.location.href = authorization_url;
+            } catch (error) {
+                showError(errorMessage, error);
+            }
+        });
+
+        // The identity provider sends the user back here with a code.
+        const params = new URLSearchParams(window.location.search);
+        if (params.has('state')) {
+            window.history.replaceState(null, '', window.location.pathname);
+            if (params.has('code')) {
+                postLogin('login/sso/callback', { code: params.get('code'), state: params.get('state') })
+                    .then(finishLogin)
+                    .catch(error => showError(errorMessage, error));
+            } else {
+                showError(errorMessage, new Error('Вход через SSO отменён'));
+            }
+        }
+    }
+
     if (logoutBtn) {
-        logoutBtn.addEventListener('click', () => {
+        logoutBtn.addEventListener('click', async () => {
+            try {
+                await api.logout();
+            } catch (error) {
+                console.error('Error logging out:', error);
+            }
             localStorage.removeItem('authToken');
+            localStorage.removeItem('refreshToken');
             localStorage.removeItem('userRole');
+            localStorage.removeItem('permissions');
             window.location.href = 'index.html';
         });
     }
@@ -51,3 +65,72 @@ document.addEventListener('DOMContentLoaded', () => {
         }
     }
 });
+
+async function finishLogin(result) {
+    if (result.mfa_required) {
+        result = await completeMfa(result);
+    }
+
+    const { token, refresh_token, role, permissions } = result;
+    localStorage.setItem('authToken', token);
+    localStorage.setItem('refreshToken', refresh_token);
+    localStorage.setItem('userRole', role);
+    localStorage.setItem('permissions', JSON.stringify(permissions || []));
+
+    window.location.href = 'dashboard.html';
+}
+
+function showError(element, error) {
+    element.textContent = error.message;
+    element.style.display = 'block';
+}
+
+// problemMessage turns an RFC 7807 error response into a message, listing
+// the fields the server rejected.
+function problemMessage(problem, fallback) {
+    let message = problem.detail || problem.title || fallback;
+    if (Array.isArray(problem.errors) && problem.errors.length > 0) {
+        const fields = problem.errors.map(e => e.field ? `${e.field}: ${e.message}` : e.message);
+        message += ` (${fields.join('; ')})`;
+    }
+    return message;
+}
+
+async function postLogin(path, body) {
+    const response = await fetch(`http://localhost:8080/${path}`, {
+        method: 'POST',
+        headers: {
+            'Content-Type': 'application/json'
+        },
+        // The SSO endpoints keep the state of a login in a cookie.
+        credentials: 'include',
+        body: JSON.stringify(body)
+    });
+
+    if (!response.ok) {
+        const error = await response.json();
+        throw new Error(problemMessage(error, 'Ошибка авторизации'));
+    }
+    return response.json();
+}
+
+async function completeMfa(challenge) {
+    const mfa_token = challenge.mfa_token;
+
+    if (challenge.mfa_enrollment_required) {
+        const enrollment = await postLogin('login/mfa/enroll', { mfa_token });
+        alert('Для вашей роли обязательна двухфакторная аутентификация.\n' +
+            'Добавьте ключ в приложение-аутентификатор:\n' + enrollment.secret);
+    }
+
+    const code = prompt('Введите код из приложения-аутентификатора или резервный код');
+    if (!code) {
+        throw new Error('Вход отменён');
+    }
+
+    const result = await postLogin('login/mfa', { mfa_token, code });
+    if (result.recovery_codes) {
+        alert('Сохраните резервные коды, они показываются один раз:\n' + result.recovery_codes.join('\n'));
+    }
+    return result;
+}
@@ -1,24 +1,50 @@
+const formPermissions = {
+    'add-node-form': 'node:create',
+    'edit-node-form': 'node:update',
+    'delete-node-form': 'node:delete',
+    'add-device-form': 'device:create',
+    'edit-device-form': 'device:update',
+    'delete-device-form': 'device:delete'
+};
+
+// Versions of the node and device loaded into the edit forms; updates are
+// refused when someone else has changed them since.
+let editedNodeVersion = null;
+let editedDeviceVersion = null;
+
+const formHandlers = {
+    'add-node-form': addNode,
+    'edit-node-form': updateNode,
+    'delete-node-form': deleteNode,
+    'add-device-form': addDevice,
+    'edit-device-form': updateDevice,
+    'delete-device-form': deleteDevice
+};
+
 document.addEventListener('DOMContentLoaded', () => {
-    const userRole = localStorage.getItem('userRole');
+    const permissions = JSON.parse(localStorage.getItem('permissions') || '[]');
+
+    const allowedForms = Object.keys(formPermissions).filter(formId => {
+        const allowed = permissions.includes(formPermissions[formId]);
+        if (!allowed) {
+            document.querySelectorAll(`#${formId} .btn`).forEach(btn => {
+                btn.disabled = true;
+                btn.title = 'Недостаточно прав';
+            });
+        }
+        return allowed;
+    });
 
-    if (userRole !== 'admin') {
-        document.querySelectorAll('.btn:not(.logout-btn)').forEach(btn => {
-            btn.disabled = true;
-            btn.title = 'Требуются права администратора';
-        });
+    if (allowedForms.length === 0) {
         return;
     }
 
     initNodeSelects();
     initDeviceSelects();
 
-    document.getElementById('add-node-form').addEventListener('submit', addNode);
-    document.getElementById('edit-node-form').addEventListener('submit', updateNode);
-    document.getElementById('delete-node-form').addEventListener('submit', deleteNode);
-
-    document.getElementById('add-device-form').addEventListener('submit', addDevice);
-    document.getElementById('edit-device-form').addEventListener('submit', updateDevice);
-    document.getElementById('delete-device-form').addEventListener('submit', deleteDevice);
+    allowedForms.forEach(formId => {
+        document.getElementById(formId).addEventListener('submit', formHandlers[formId]);
+    });
 
     document.getElementById('edit-node-id').addEventListener('change', loadNodeDetails);
     document.getElementById('edit-device-id').addEventListener('change', loadDeviceDetails);
@@ -45,17 +71,56 @@ async function initNodeSelects() {
     }
 }
 
-async function initDeviceSelects() {
+// Device selects show one page of the inventory at a time, narrowed by the
+// search field next to them; "more" appends the next page.
+const DEVICE_PAGE_SIZE = 50;
+
+const deviceSelects = {
+    'edit-device-id': {search: 'edit-device-search', more: 'edit-device-more', cursor: null},
+    'delete-device-id': {search: 'delete-device-search', more: 'delete-device-more', cursor: null}
+};
+
+function initDeviceSelects() {
+    Object.entries(deviceSelects).forEach(([selectId, state]) => {
+        if (!state.bound) {
+            let timer = null;
+            document.getElementById(state.search).addEventListener('input', () => {
+                clearTimeout(timer);
+                timer = setTimeout(() => loadDevicePage(selectId, false), 300);
+            });
+            document.getElementById(state.more).addEventListener('click', () => loadDevicePage(selectId, true));
+            state.bound = true;
+        }
+        loadDevicePage(selectId, false);
+    });
+}
+
+async function loadDevicePage(selectId, append) {
+    const state = deviceSelects[selectId];
+    const select = document.getElementById(selectId);
     try {
-        const devices = await api.getAllDevices();
+        const page = await api.getDevices({
+            q: document.getElementById(state.search).value.trim(),
+            limit: DEVICE_PAGE_SIZE,
+            cursor: append ? state.cursor : undefined
+        });
 
-        const deviceOptions = devices.map(device => ({
+        const options = page.items.map(device => ({
             id: device.id,
             name: `${device.type}: ${device.model} (${device.serial})`
         }));
+        if (append) {
+            const existing = Array.from(select.options).slice(1).map(option => ({
+                id: option.value,
+                name: option.textContent
+            }));
+            populateSelect(selectId, [...existing, ...options]);
+        } else {
+            populateSelect(selectId, options);
+        }
 
-        populateSelect('edit-device-id', deviceOptions);
-        populateSelect('delete-device-id', deviceOptions);
+        state.cursor = page.next_cursor || null;
+        document.getElementById(state.more).hidden = !state.cursor;
     } catch (error) {
         console.error('Error loading devices:', error);
         alert('Не удалось загрузить список устройств');
@@ -90,6 +155,7 @@ async function loadNodeDetails() {
 
     try {
         const node = await api.getNode(nodeId);
+        editedNodeVersion = node.version;
         document.getElementById('edit-node-name').value = node.name;
         document.getElementById('edit-node-description').value = node.description || '';
         document.getElementById('edit-node-parent').value = node.parent_id || '';
@@ -108,6 +174,7 @@ async function loadDeviceDetails() {
         if (!device) {
             throw new Error('Устройство не найдено');
         }
+        editedDeviceVersion = device.version;
 
         const setValue = (id, value) => {
             const el = document.getElementById(id);
@@ -171,7 +238,7 @@ async function updateNode(e) {
     }
 
     try {
-        await api.updateNode(nodeId, nodeData);
+        await api.updateNode(nodeId, nodeData, editedNodeVersion);
         alert('Узел успешно обновлен');
         initNodeSelects();
         refreshTree();
@@ -257,7 +324,7 @@ async function updateDevice(e) {
     }
 
     try {
-        await api.updateDevice(deviceId, deviceData);
+        await api.updateDevice(deviceId, deviceData, editedDeviceVersion);
         alert('Устройство успешно обновлено');
         initDeviceSelects();
         refreshTree();
@@ -0,0 +1,130 @@
+// Package apperror defines the kinds of errors services and repositories
+// report, so that the API maps them to HTTP statuses in a single place.
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Kind classifies an error by what the client can do about it.
+type Kind int
+
+const (
+	// Internal errors are not the client's fault; their message is not
+	// shown to it.
+	Internal Kind = iota
+	// BadRequest is a request that could not be understood at all.
+	BadRequest
+	// Validation is a well-formed request with invalid values.
+	Validation
+	Unauthorized
+	Forbidden
+	NotFound
+	// Conflict is a request that clashes with the current state.
+	Conflict
+	// PreconditionFailed is a conditional request whose condition no
+	// longer holds.
+	PreconditionFailed
+	TooManyRequests
+	// Unavailable is a dependency that is down for the moment.
+	Unavailable
+)
+
+// FieldError describes what is wrong with one field of a request. Field
+// is the JSON name of the field and empty for problems with the request as
+// a whole.
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error of a known kind. Its message is meant for clients and
+// therefore must not reveal internals; those belong into Err.
+type Error struct {
+	Kind    Kind
+	Message string
+	Fields  []FieldError
+	Err     error
+
+	// sentinel is the error this one was derived from by Because.
+	sentinel *Error
+}
+
+// New returns an error of kind. Package-level sentinels are declared with
+// it, so that errors.Is keeps working on them.
+func New(kind Kind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// Wrap returns an error of kind caused by err.
+func Wrap(kind Kind, message string, err error) *Error {
+	return &Error{Kind: kind, Message: message, Err: err}
+}
+
+// Invalid returns a validation error listing the problems of fields.
+func Invalid(message string, fields ...FieldError) *Error {
+	return &Error{Kind: Validation, Message: message, Fields: fields}
+}
+
+// Because returns an error like e whose message is followed by reason, for
+// details that differ from one occurrence to the next. errors.Is matches it
+// with e.
+func (e *Error) Because(reason string) *Error {
+	return &Error{Kind: e.Kind, Message: e.Message + ": " + reason, Fields: e.Fields, Err: e.Err, sentinel: e}
+}
+
+// Is reports whether e was derived from target by Because.
+func (e *Error) Is(target error) bool {
+	return e.sentinel != nil && e.sentinel == target
+}
+
+func (e *Error) Error() string {
+	message := e.Message
+	for _, field := range e.Fields {
+		if field.Field != "" {
+			message += fmt.Sprintf("; %s: %s", field.Field, field.Message)
+		} else {
+			message += "; " + field.Message
+		}
+	}
+	if e.Err != nil {
+		message += ": " + e.Err.Error()
+	}
+	return message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of the first Error in the chain of err, and
+// Internal when there is none.
+func KindOf(err error) Kind {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Kind
+	}
+	return Internal
+}
+
+// Detail returns the message of the first Error in the chain of err as a
+// sentence for clients, and an empty string when there is none or it is
+// internal.
+func Detail(err error) string {
+	var appErr *Error
+	if !errors.As(err, &appErr) || appErr.Kind == Internal || appErr.Message == "" {
+		return ""
+	}
+	return strings.ToUpper(appErr.Message[:1]) + appErr.Message[1:]
+}
+
+// FieldsOf returns the field errors of the first Error in the chain of err.
+func FieldsOf(err error) []FieldError {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Fields
+	}
+	return nil
+}
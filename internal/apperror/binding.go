@@ -0,0 +1,88 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// JSONFieldNames makes validate report fields by their JSON or form names
+// rather than by the names of the Go struct fields.
+func JSONFieldNames(validate *validator.Validate) {
+	validate.RegisterTagNameFunc(func(field reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+			if name == "-" {
+				return ""
+			}
+			if name != "" {
+				return name
+			}
+		}
+		return field.Name
+	})
+}
+
+// FieldErrorOf describes a failed validation of a field.
+func FieldErrorOf(err validator.FieldError) FieldError {
+	field := strings.ToLower(err.Field())
+	switch err.Tag() {
+	case "required":
+		return FieldError{Field: field, Message: "is required"}
+	case "oneof":
+		return FieldError{Field: field, Message: "must be one of: " + err.Param()}
+	case "min":
+		return FieldError{Field: field, Message: fmt.Sprintf("must be at least %s%s", err.Param(), sizeUnit(err))}
+	case "max":
+		return FieldError{Field: field, Message: fmt.Sprintf("must be at most %s%s", err.Param(), sizeUnit(err))}
+	default:
+		return FieldError{Field: field, Message: "is invalid"}
+	}
+}
+
+func sizeUnit(err validator.FieldError) string {
+	if err.Kind() == reflect.String {
+		return " characters long"
+	}
+	if err.Kind() == reflect.Slice || err.Kind() == reflect.Map {
+		return " items"
+	}
+	return ""
+}
+
+// FromBinding turns an error of decoding and validating a request into a
+// BadRequest error when the request could not be decoded, and into a
+// Validation error listing the offending fields otherwise.
+func FromBinding(message string, err error) *Error {
+	var fieldErrors validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &fieldErrors):
+		fields := make([]FieldError, 0, len(fieldErrors))
+		for _, fieldError := range fieldErrors {
+			fields = append(fields, FieldErrorOf(fieldError))
+		}
+		return Invalid(message, fields...)
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if i := strings.LastIndex(field, "."); i >= 0 {
+			field = field[i+1:]
+		}
+		return Invalid(message, FieldError{Field: field, Message: "must be of type " + typeErr.Type.String()})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		return Invalid(message, FieldError{Field: field, Message: "is not a known field"})
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return Wrap(BadRequest, "request body is not valid JSON", err)
+	case errors.Is(err, io.EOF):
+		return Wrap(BadRequest, "request body is empty", err)
+	default:
+		return Wrap(BadRequest, message, err)
+	}
+}
@@ -14,6 +14,50 @@ type Config struct {
 	ServerPort   string
 	JWTSecret    string
 	SeedTestData bool
+	// DevMode relaxes checks meant for production, such as the strength of
+	// the JWT secret.
+	DevMode bool
+	// TrustedProxies lists the addresses or CIDRs of the reverse proxies
+	// whose X-Forwarded-For header names the client, separated by commas.
+	// Empty trusts none, so the client is the peer of the connection.
+	TrustedProxies string
+
+	// JWTPreviousSecrets and JWTPublicKeyFiles list retired keys whose
+	// tokens are still accepted, separated by commas. JWTPrivateKeyFile,
+	// when set, signs tokens instead of JWTSecret.
+	JWTPreviousSecrets string
+	JWTPrivateKeyFile  string
+	JWTPublicKeyFiles  string
+	JWTIssuer          string
+	JWTAudience        string
+
+	// LDAPURL enables logins against an LDAP directory; empty keeps local
+	// passwords only.
+	LDAPURL            string
+	LDAPStartTLS       bool
+	LDAPBindDN         string
+	LDAPBindPassword   string
+	LDAPBaseDN         string
+	LDAPUserFilter     string
+	LDAPGroupAttribute string
+	// LDAPGroupRoles maps groups to roles as "group DN:role;...".
+	LDAPGroupRoles  string
+	LDAPDefaultRole string
+
+	// OIDCIssuer enables single sign-on through an OpenID Connect
+	// provider; empty disables it.
+	OIDCIssuer       string
+	OIDCClientID     string
+	OIDCClientSecret string
+	// OIDCRedirectURL is the login page of the frontend, which the provider
+	// sends users back to.
+	OIDCRedirectURL string
+	OIDCScopes      string
+	OIDCLoginClaim  string
+	OIDCGroupsClaim string
+	// OIDCGroupRoles maps groups to roles as "group:role;...".
+	OIDCGroupRoles  string
+	OIDCDefaultRole string
 }
 
 func LoadConfig() *Config {
@@ -24,8 +68,37 @@ func LoadConfig() *Config {
 		DBPassword:   getEnv("DB_PASSWORD", "postgres"),
 		DBName:       getEnv("DB_NAME", "equipment_db"),
 		ServerPort:   getEnv("SERVER_PORT", "8080"),
-		JWTSecret:    getEnv("JWT_SECRET", "secret"),
+		JWTSecret:    getEnv("JWT_SECRET", ""),
 		SeedTestData: getEnvAsBool("SEED_TEST_DATA", false),
+		DevMode:      getEnvAsBool("DEV_MODE", false),
+
+		TrustedProxies: getEnv("TRUSTED_PROXIES", ""),
+
+		JWTPreviousSecrets: getEnv("JWT_PREVIOUS_SECRETS", ""),
+		JWTPrivateKeyFile:  getEnv("JWT_PRIVATE_KEY_FILE", ""),
+		JWTPublicKeyFiles:  getEnv("JWT_PUBLIC_KEY_FILES", ""),
+		JWTIssuer:          getEnv("JWT_ISSUER", "equipment-management"),
+		JWTAudience:        getEnv("JWT_AUDIENCE", "equipment-management"),
+
+		LDAPURL:            getEnv("LDAP_URL", ""),
+		LDAPStartTLS:       getEnvAsBool("LDAP_START_TLS", false),
+		LDAPBindDN:         getEnv("LDAP_BIND_DN", ""),
+		LDAPBindPassword:   getEnv("LDAP_BIND_PASSWORD", ""),
+		LDAPBaseDN:         getEnv("LDAP_BASE_DN", ""),
+		LDAPUserFilter:     getEnv("LDAP_USER_FILTER", "(uid=%s)"),
+		LDAPGroupAttribute: getEnv("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		LDAPGroupRoles:     getEnv("LDAP_GROUP_ROLES", ""),
+		LDAPDefaultRole:    getEnv("LDAP_DEFAULT_ROLE", ""),
+
+		OIDCIssuer:       getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:     getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret: getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:  getEnv("OIDC_REDIRECT_URL", "http://localhost:5500/index.html"),
+		OIDCScopes:       getEnv("OIDC_SCOPES", "openid profile email"),
+		OIDCLoginClaim:   getEnv("OIDC_LOGIN_CLAIM", "preferred_username"),
+		OIDCGroupsClaim:  getEnv("OIDC_GROUPS_CLAIM", "groups"),
+		OIDCGroupRoles:   getEnv("OIDC_GROUP_ROLES", ""),
+		OIDCDefaultRole:  getEnv("OIDC_DEFAULT_ROLE", ""),
 	}
 }
 
@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyController manages the API keys of service accounts.
+type APIKeyController struct {
+	service *service.APIKeyService
+}
+
+func NewAPIKeyController(service *service.APIKeyService) *APIKeyController {
+	return &APIKeyController{service: service}
+}
+
+// CreateKey issues a key and returns it, which happens only this once.
+func (c *APIKeyController) CreateKey(ctx *gin.Context) {
+	userID, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	key, secret, err := c.service.CreateKey(userID, &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to create API key")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, dto.CreatedAPIKeyResponse{
+		APIKeyResponse: c.service.ToAPIKeyResponse(key),
+		Key:            secret,
+	})
+}
+
+func (c *APIKeyController) GetKeys(ctx *gin.Context) {
+	userID, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	keys, err := c.service.ListKeys(userID)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get API keys")
+		return
+	}
+
+	response := make([]dto.APIKeyResponse, len(keys))
+	for i := range keys {
+		response[i] = c.service.ToAPIKeyResponse(&keys[i])
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *APIKeyController) RevokeKey(ctx *gin.Context) {
+	userID, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+	keyID, err := strconv.ParseUint(ctx.Param("keyId"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	if err := c.service.RevokeKey(userID, uint(keyID)); err != nil {
+		problem.Error(ctx, err, "Failed to revoke API key")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
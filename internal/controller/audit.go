@@ -0,0 +1,84 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/middleware"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/repository"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type AuditController struct {
+	service *service.AuditService
+}
+
+func NewAuditController(service *service.AuditService) *AuditController {
+	return &AuditController{service: service}
+}
+
+func (c *AuditController) GetEvents(ctx *gin.Context) {
+	var query dto.AuditQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		problem.Bind(ctx, err, "Invalid query parameters")
+		return
+	}
+
+	records, next, err := c.service.ListEvents(&query)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get audit events")
+		return
+	}
+
+	c.respondEvents(ctx, records, next)
+}
+
+func (c *AuditController) GetDeviceHistory(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	var query dto.AuditQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		problem.Bind(ctx, err, "Invalid query parameters")
+		return
+	}
+
+	records, next, err := c.service.GetDeviceHistory(actorFrom(ctx), uint(id), &query)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get device history")
+		return
+	}
+
+	c.respondEvents(ctx, records, next)
+}
+
+func (c *AuditController) respondEvents(ctx *gin.Context, records []repository.AuditRecord, next uint) {
+	response := dto.AuditListResponse{
+		Items:      make([]dto.AuditEventResponse, len(records)),
+		NextCursor: next,
+	}
+	for i, record := range records {
+		response.Items[i] = c.service.ToAuditEventResponse(&record)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// actorFrom identifies the authenticated user and the request for the
+// audit log, along with the subtree the user is restricted to.
+func actorFrom(ctx *gin.Context) repository.Actor {
+	actor := repository.Actor{RequestID: ctx.GetString("requestID")}
+	if id, ok := middleware.CurrentUserID(ctx); ok {
+		actor.UserID = &id
+	}
+	if scope, ok := middleware.CurrentScope(ctx); ok {
+		actor.Scope = &scope
+	}
+	return actor
+}
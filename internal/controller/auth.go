@@ -1,15 +1,14 @@
 package controller
 
 import (
-	"equipment-management/internal/repository"
 	"net/http"
 
+	"equipment-management/internal/problem"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 
-	"equipment-management/internal/config"
-	"equipment-management/internal/models"
-	"equipment-management/pkg/auth"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/middleware"
+	"equipment-management/internal/service"
 )
 
 type LoginRequest struct {
@@ -17,42 +16,184 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+type MFALoginRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required,max=64"`
+}
+
+type MFAEnrollRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
 type LoginResponse struct {
-	Token string `json:"token"`
-	Role  string `json:"role"`
+	Token        string   `json:"token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Role         string   `json:"role"`
+	Permissions  []string `json:"permissions"`
+	// RecoveryCodes are returned once, when a login completes an enrollment.
+	RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// MFAChallengeResponse asks the client to complete the login with a second
+// factor at /login/mfa.
+type MFAChallengeResponse struct {
+	MFARequired   bool   `json:"mfa_required"`
+	MFAToken      string `json:"mfa_token"`
+	ExpiresIn     int    `json:"expires_in"`
+	EnrollmentDue bool   `json:"mfa_enrollment_required"`
 }
 
-func Login(c *gin.Context) {
+type AuthController struct {
+	service *service.AuthService
+}
+
+func NewAuthController(service *service.AuthService) *AuthController {
+	return &AuthController{service: service}
+}
+
+func (c *AuthController) Login(ctx *gin.Context) {
 	var req LoginRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request")
+		return
+	}
+
+	tokens, challenge, err := c.service.Login(req.Login, req.Password, clientInfo(ctx))
+	if err != nil {
+		problem.Error(ctx, err, "Failed to generate token")
+		return
+	}
+
+	respondLogin(ctx, tokens, challenge)
+}
+
+// CompleteMFA is the second step of a login challenged for a second factor.
+func (c *AuthController) CompleteMFA(ctx *gin.Context) {
+	var req MFALoginRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request")
+		return
+	}
+
+	tokens, recoveryCodes, err := c.service.CompleteMFA(req.MFAToken, req.Code, clientInfo(ctx))
+	if err != nil {
+		problem.Error(ctx, err, "Failed to generate token")
+		return
+	}
+
+	response := toLoginResponse(tokens)
+	response.RecoveryCodes = recoveryCodes
+	ctx.JSON(http.StatusOK, response)
+}
+
+// EnrollMFA sets up an authenticator app during a login whose role requires
+// a second factor the user does not have yet.
+func (c *AuthController) EnrollMFA(ctx *gin.Context) {
+	var req MFAEnrollRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request")
+		return
+	}
+
+	enrollment, err := c.service.StartMFAEnrollment(req.MFAToken)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to start enrollment")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toEnrollmentResponse(enrollment))
+}
+
+func (c *AuthController) Refresh(ctx *gin.Context) {
+	var req RefreshRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request")
+		return
+	}
+
+	tokens, err := c.service.Refresh(req.RefreshToken, clientInfo(ctx))
+	if err != nil {
+		problem.Error(ctx, err, "Failed to refresh token")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toLoginResponse(tokens))
+}
+
+func (c *AuthController) Logout(ctx *gin.Context) {
+	sessionID, ok := middleware.CurrentSessionID(ctx)
+	if !ok {
+		problem.Respond(ctx, http.StatusUnauthorized, "Invalid token")
 		return
 	}
 
-	var user models.User
-	if err := repository.DB.Where("login = ?", req.Login).First(&user).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		}
+	if err := c.service.Logout(sessionID); err != nil {
+		problem.Error(ctx, err, "Failed to log out")
 		return
 	}
 
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+	ctx.Status(http.StatusNoContent)
+}
+
+// GetLoginAttempts lists recorded login attempts for security review.
+func (c *AuthController) GetLoginAttempts(ctx *gin.Context) {
+	var query dto.LoginAttemptQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		problem.Bind(ctx, err, "Invalid query parameters")
 		return
 	}
 
-	cfg := config.LoadConfig()
-	token, err := auth.GenerateJWT(user.ID, user.Role, cfg.JWTSecret)
+	attempts, next, err := c.service.ListLoginAttempts(&query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		problem.Error(ctx, err, "Failed to get login attempts")
+		return
+	}
+
+	response := dto.LoginAttemptListResponse{
+		Items:      make([]dto.LoginAttemptResponse, len(attempts)),
+		NextCursor: next,
+	}
+	for i, attempt := range attempts {
+		response.Items[i] = c.service.ToLoginAttemptResponse(&attempt)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func clientInfo(ctx *gin.Context) service.ClientInfo {
+	return service.ClientInfo{
+		IP:        ctx.ClientIP(),
+		UserAgent: ctx.Request.UserAgent(),
+	}
+}
+
+func toLoginResponse(tokens *service.TokenPair) LoginResponse {
+	return LoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
+		Role:         tokens.User.Role,
+		Permissions:  tokens.Permissions,
+	}
+}
+
+// respondLogin writes the result of the first step of a login: either the
+// tokens or the challenge for a second factor.
+func respondLogin(ctx *gin.Context, tokens *service.TokenPair, challenge *service.MFAChallenge) {
+	if challenge != nil {
+		ctx.JSON(http.StatusOK, MFAChallengeResponse{
+			MFARequired:   true,
+			MFAToken:      challenge.Token,
+			ExpiresIn:     int(challenge.ExpiresIn.Seconds()),
+			EnrollmentDue: challenge.Enroll,
+		})
 		return
 	}
 
-	c.JSON(http.StatusOK, LoginResponse{
-		Token: token,
-		Role:  user.Role,
-	})
+	ctx.JSON(http.StatusOK, toLoginResponse(tokens))
 }
@@ -1,10 +1,16 @@
 package controller
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"equipment-management/internal/dto"
+	"equipment-management/internal/problem"
 	"equipment-management/internal/service"
 	"github.com/gin-gonic/gin"
 )
@@ -19,14 +25,13 @@ func NewDeviceController(service *service.DeviceService) *DeviceController {
 
 func (c *DeviceController) CreateDevice(ctx *gin.Context) {
 	var req dto.CreateDeviceRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindStrictJSON(ctx, &req) {
 		return
 	}
 
-	device, err := c.service.CreateDevice(&req)
+	device, err := c.service.CreateDevice(actorFrom(ctx), &req)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create device"})
+		problem.Error(ctx, err, "Failed to create device")
 		return
 	}
 
@@ -37,68 +42,244 @@ func (c *DeviceController) CreateDevice(ctx *gin.Context) {
 func (c *DeviceController) GetDevice(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
 		return
 	}
 
-	device, err := c.service.GetDevice(uint(id))
+	device, err := c.service.GetDevice(actorFrom(ctx), uint(id))
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		problem.Error(ctx, err, "Failed to get device")
 		return
 	}
 
 	response := c.service.ToDeviceResponse(device)
+	setETag(ctx, device.Version)
 	ctx.JSON(http.StatusOK, response)
 }
 
+// UpdateDevice requires the If-Match header with the ETag of the device as
+// read by the client, so that changes made since are not overwritten.
 func (c *DeviceController) UpdateDevice(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
 		return
 	}
 
 	var req dto.UpdateDeviceRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindStrictJSON(ctx, &req) {
+		return
+	}
+
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
 		return
 	}
 
-	device, err := c.service.UpdateDevice(uint(id), &req)
+	device, err := c.service.UpdateDevice(actorFrom(ctx), uint(id), version, &req)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update device"})
+		problem.Error(ctx, err, "Failed to update device")
 		return
 	}
 
 	response := c.service.ToDeviceResponse(device)
+	setETag(ctx, device.Version)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// PatchDevice changes the device by a JSON merge patch.
+func (c *DeviceController) PatchDevice(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	patch, ok := readMergePatch(ctx)
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+
+	device, err := c.service.PatchDevice(actorFrom(ctx), uint(id), version, patch)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update device")
+		return
+	}
+
+	response := c.service.ToDeviceResponse(device)
+	setETag(ctx, device.Version)
 	ctx.JSON(http.StatusOK, response)
 }
 
 func (c *DeviceController) DeleteDevice(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid device ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
 		return
 	}
 
-	if err := c.service.DeleteDevice(uint(id)); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete device"})
+	if err := c.service.DeleteDevice(actorFrom(ctx), uint(id)); err != nil {
+		problem.Error(ctx, err, "Failed to delete device")
 		return
 	}
 
 	ctx.Status(http.StatusNoContent)
 }
 
+func (c *DeviceController) TransitionDevice(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	var req dto.DeviceTransitionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	device, transition, err := c.service.TransitionDevice(actorFrom(ctx), uint(id), &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to change device status")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"device":     c.service.ToDeviceResponse(device),
+		"transition": c.service.ToTransitionResponse(transition),
+	})
+}
+
+func (c *DeviceController) GetTransitions(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid device ID")
+		return
+	}
+
+	transitions, err := c.service.GetTransitions(actorFrom(ctx), uint(id))
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get device transitions")
+		return
+	}
+
+	response := make([]dto.DeviceTransitionResponse, len(transitions))
+	for i, transition := range transitions {
+		response[i] = c.service.ToTransitionResponse(&transition)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+// maxImportSize limits the size of an uploaded CSV file.
+const maxImportSize = 10 << 20
+
+// ImportDevices accepts a CSV file either as the "file" field of a multipart
+// form or as the raw request body.
+func (c *DeviceController) ImportDevices(ctx *gin.Context) {
+	dryRun, err := strconv.ParseBool(ctx.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid dry_run value")
+		return
+	}
+
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxImportSize)
+
+	var file io.Reader = ctx.Request.Body
+	if strings.HasPrefix(ctx.ContentType(), "multipart/form-data") {
+		header, err := ctx.FormFile("file")
+		if err != nil {
+			problem.Respond(ctx, http.StatusBadRequest, "CSV file is required")
+			return
+		}
+		upload, err := header.Open()
+		if err != nil {
+			problem.Respond(ctx, http.StatusBadRequest, "Failed to read CSV file")
+			return
+		}
+		defer upload.Close()
+		file = upload
+	}
+
+	report, err := c.service.ImportDevices(actorFrom(ctx), file, dryRun)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to import devices")
+		return
+	}
+
+	switch {
+	case !report.Valid:
+		ctx.JSON(http.StatusUnprocessableEntity, report)
+	case dryRun:
+		ctx.JSON(http.StatusOK, report)
+	default:
+		ctx.JSON(http.StatusCreated, report)
+	}
+}
+
+var exportContentTypes = map[string]string{
+	service.ExportFormatCSV:   "text/csv; charset=utf-8",
+	service.ExportFormatJSONL: "application/x-ndjson",
+	service.ExportFormatXLSX:  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+}
+
+// ExportDevices streams the devices matching the list filters as a file.
+func (c *DeviceController) ExportDevices(ctx *gin.Context) {
+	format := ctx.DefaultQuery("format", service.ExportFormatCSV)
+	if !service.ValidExportFormat(format) {
+		problem.Respond(ctx, http.StatusBadRequest, "Unsupported export format")
+		return
+	}
+
+	var query dto.DeviceListQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		problem.Bind(ctx, err, "Invalid query parameters")
+		return
+	}
+
+	filename := fmt.Sprintf("devices-%s.%s", time.Now().Format("20060102-150405"), format)
+	ctx.Header("Content-Type", exportContentTypes[format])
+	ctx.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	if err := c.service.ExportDevices(actorFrom(ctx), &query, format, ctx.Writer); err != nil {
+		if ctx.Writer.Written() {
+			log.Printf("Device export aborted: %v", err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Writer.Header().Del("Content-Type")
+		ctx.Writer.Header().Del("Content-Disposition")
+		problem.Error(ctx, err, "Failed to export devices")
+	}
+}
+
 func (c *DeviceController) GetAllDevices(ctx *gin.Context) {
-	devices, err := c.service.GetAllDevices()
+	var query dto.DeviceListQuery
+	if err := ctx.ShouldBindQuery(&query); err != nil {
+		problem.Bind(ctx, err, "Invalid query parameters")
+		return
+	}
+
+	devices, total, next, err := c.service.ListDevices(actorFrom(ctx), &query)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get devices"})
+		problem.Error(ctx, err, "Failed to get devices")
 		return
 	}
 
-	response := make([]dto.DeviceResponse, len(devices))
+	response := dto.DeviceListResponse{
+		Items:      make([]dto.DeviceResponse, len(devices)),
+		Total:      total,
+		NextCursor: next,
+	}
 	for i, device := range devices {
-		response[i] = c.service.ToDeviceResponse(&device)
+		response.Items[i] = c.service.ToDeviceResponse(&device)
 	}
 
 	ctx.JSON(http.StatusOK, response)
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"equipment-management/internal/problem"
+	"github.com/gin-gonic/gin"
+)
+
+// setETag tags the response with the version of the object it carries.
+func setETag(ctx *gin.Context, version uint) {
+	ctx.Header("ETag", `"`+strconv.FormatUint(uint64(version), 10)+`"`)
+}
+
+// ifMatchVersion reads the version an update is based on from the If-Match
+// header, which is required. "*" matches any version and yields 0. It
+// writes the error response and reports false when the header is missing
+// or not an ETag of ours.
+func ifMatchVersion(ctx *gin.Context) (uint, bool) {
+	header := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if header == "" {
+		problem.Respond(ctx, http.StatusPreconditionRequired, "If-Match header is required")
+		return 0, false
+	}
+	if header == "*" {
+		return 0, true
+	}
+
+	// Weak tags never match for If-Match, and ours are never weak.
+	tag, quoted := strings.CutPrefix(header, `"`)
+	tag, closed := strings.CutSuffix(tag, `"`)
+	version, err := strconv.ParseUint(tag, 10, 32)
+	if !quoted || !closed || err != nil || version == 0 {
+		problem.Respond(ctx, http.StatusPreconditionFailed, "The object was changed by someone else, reload it and try again")
+		return 0, false
+	}
+	return uint(version), true
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"net/http"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/middleware"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// MFAController manages the second factor of the current user and lets
+// admins reset it.
+type MFAController struct {
+	service *service.MFAService
+}
+
+func NewMFAController(service *service.MFAService) *MFAController {
+	return &MFAController{service: service}
+}
+
+func (c *MFAController) GetStatus(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+
+	status, err := c.service.GetStatus(userID)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get two-factor status")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.MFAStatusResponse{
+		Enabled:           status.Enabled,
+		Required:          status.Required,
+		RecoveryCodesLeft: status.RecoveryCodesLeft,
+	})
+}
+
+// StartEnrollment generates the secret of a new authenticator app.
+func (c *MFAController) StartEnrollment(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+
+	enrollment, err := c.service.StartEnrollment(userID)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to start enrollment")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, toEnrollmentResponse(enrollment))
+}
+
+// ConfirmEnrollment enables the authenticator app and returns the recovery
+// codes, which are shown only this once.
+func (c *MFAController) ConfirmEnrollment(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Code is required")
+		return
+	}
+
+	codes, err := c.service.ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to confirm enrollment")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func (c *MFAController) Disable(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Code is required")
+		return
+	}
+
+	if err := c.service.Disable(userID, req.Code); err != nil {
+		problem.Error(ctx, err, "Failed to disable two-factor authentication")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *MFAController) RegenerateRecoveryCodes(ctx *gin.Context) {
+	userID, ok := currentUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.MFACodeRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Code is required")
+		return
+	}
+
+	codes, err := c.service.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to generate recovery codes")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Reset removes the second factor of another user, e.g. after the loss of
+// the phone.
+func (c *MFAController) Reset(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.service.Reset(id); err != nil {
+		problem.Error(ctx, err, "Failed to reset two-factor authentication")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func currentUserID(ctx *gin.Context) (uint, bool) {
+	userID, ok := middleware.CurrentUserID(ctx)
+	if !ok {
+		problem.Respond(ctx, http.StatusUnauthorized, "Invalid token")
+	}
+	return userID, ok
+}
+
+func toEnrollmentResponse(enrollment *service.TOTPEnrollment) dto.TOTPEnrollmentResponse {
+	return dto.TOTPEnrollmentResponse{
+		Secret:     enrollment.Secret,
+		OtpauthURI: enrollment.URI,
+	}
+}
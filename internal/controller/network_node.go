@@ -5,28 +5,32 @@ import (
 	"strconv"
 
 	"equipment-management/internal/dto"
+	"equipment-management/internal/middleware"
+	"equipment-management/internal/models"
+	"equipment-management/internal/problem"
 	"equipment-management/internal/service"
 	"github.com/gin-gonic/gin"
 )
 
 type NetworkNodeController struct {
 	service *service.NetworkNodeService
+	roles   *service.RoleService
 }
 
-func NewNetworkNodeController(service *service.NetworkNodeService) *NetworkNodeController {
-	return &NetworkNodeController{service: service}
+func NewNetworkNodeController(service *service.NetworkNodeService, roles *service.RoleService) *NetworkNodeController {
+	return &NetworkNodeController{service: service, roles: roles}
 }
 
 func (c *NetworkNodeController) CreateNode(ctx *gin.Context) {
 	var req dto.CreateNetworkNodeRequest
 	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		problem.Bind(ctx, err, "Invalid request data")
 		return
 	}
 
-	node, err := c.service.CreateNode(&req)
+	node, err := c.service.CreateNode(actorFrom(ctx), &req)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create network node"})
+		problem.Error(ctx, err, "Failed to create network node")
 		return
 	}
 
@@ -37,79 +41,275 @@ func (c *NetworkNodeController) CreateNode(ctx *gin.Context) {
 func (c *NetworkNodeController) GetNode(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid node ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
 		return
 	}
 
-	node, err := c.service.GetNode(uint(id))
+	node, err := c.service.GetNode(actorFrom(ctx), uint(id))
 	if err != nil {
-		ctx.JSON(http.StatusNotFound, gin.H{"error": "Network node not found"})
+		problem.Error(ctx, err, "Failed to get network node")
 		return
 	}
 
 	response := c.service.ToNetworkNodeResponse(node)
+	if response.Path, err = c.service.GetBreadcrumb(actorFrom(ctx), node.ID); err != nil {
+		problem.Error(ctx, err, "Failed to get node path")
+		return
+	}
+	setETag(ctx, node.Version)
 	ctx.JSON(http.StatusOK, response)
 }
 
+// UpdateNode requires the If-Match header with the ETag of the node as read
+// by the client, so that changes made since are not overwritten.
 func (c *NetworkNodeController) UpdateNode(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid node ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
 		return
 	}
 
 	var req dto.UpdateNetworkNodeRequest
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+	if !bindStrictJSON(ctx, &req) {
+		return
+	}
+
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
 		return
 	}
 
-	node, err := c.service.UpdateNode(uint(id), &req)
+	node, err := c.service.UpdateNode(actorFrom(ctx), uint(id), version, &req)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update network node"})
+		problem.Error(ctx, err, "Failed to update network node")
 		return
 	}
 
 	response := c.service.ToNetworkNodeResponse(node)
+	setETag(ctx, node.Version)
 	ctx.JSON(http.StatusOK, response)
 }
 
+// PatchNode changes the node by a JSON merge patch.
+func (c *NetworkNodeController) PatchNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	patch, ok := readMergePatch(ctx)
+	if !ok {
+		return
+	}
+
+	version, ok := ifMatchVersion(ctx)
+	if !ok {
+		return
+	}
+
+	node, err := c.service.PatchNode(actorFrom(ctx), uint(id), version, patch)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update network node")
+		return
+	}
+
+	response := c.service.ToNetworkNodeResponse(node)
+	setETag(ctx, node.Version)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// MoveNode attaches the node with its branch to another parent.
+func (c *NetworkNodeController) MoveNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	var req dto.MoveNetworkNodeRequest
+	if !bindStrictJSON(ctx, &req) {
+		return
+	}
+
+	node, err := c.service.MoveNode(actorFrom(ctx), uint(id), &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to move network node")
+		return
+	}
+
+	response := c.service.ToNetworkNodeResponse(node)
+	setETag(ctx, node.Version)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// CopyNode clones the branch of the node under another parent.
+func (c *NetworkNodeController) CopyNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	var req dto.CopyNetworkNodeRequest
+	if !bindStrictJSON(ctx, &req) {
+		return
+	}
+
+	// Copying the devices creates devices, which needs its own permission.
+	if !req.WithoutDevices {
+		allowed, err := middleware.HasPermission(ctx, c.roles, models.PermDeviceCreate)
+		if err != nil {
+			problem.Error(ctx, err, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			problem.Respond(ctx, http.StatusForbidden, "Copying devices requires the device:create permission, copy without devices instead")
+			return
+		}
+	}
+
+	node, devices, err := c.service.CopyNode(actorFrom(ctx), uint(id), &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to copy network node")
+		return
+	}
+
+	response := c.service.ToCopyNetworkNodeResponse(node, devices)
+	ctx.JSON(http.StatusCreated, response)
+}
+
+// ReorderNodes sets the order of the children of a parent, or of the roots.
+func (c *NetworkNodeController) ReorderNodes(ctx *gin.Context) {
+	var req dto.ReorderNetworkNodesRequest
+	if !bindStrictJSON(ctx, &req) {
+		return
+	}
+
+	if err := c.service.ReorderNodes(actorFrom(ctx), &req); err != nil {
+		problem.Error(ctx, err, "Failed to reorder network nodes")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// DeleteNode deletes the node in the mode given by the mode query parameter:
+// orphan (the default), reparent, cascade or refuse.
 func (c *NetworkNodeController) DeleteNode(ctx *gin.Context) {
 	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{"error": "Invalid node ID"})
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
 		return
 	}
 
-	if err := c.service.DeleteNode(uint(id)); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete network node"})
+	mode, err := service.ParseDeleteMode(ctx.Query("mode"))
+	if err != nil {
+		problem.Error(ctx, err, "Invalid delete mode")
+		return
+	}
+
+	if err := c.service.DeleteNode(actorFrom(ctx), uint(id), mode); err != nil {
+		problem.Error(ctx, err, "Failed to delete network node")
 		return
 	}
 
 	ctx.Status(http.StatusNoContent)
 }
 
+// PreviewDeleteNode lists what DeleteNode would change for the same mode.
+func (c *NetworkNodeController) PreviewDeleteNode(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	mode, err := service.ParseDeleteMode(ctx.Query("mode"))
+	if err != nil {
+		problem.Error(ctx, err, "Invalid delete mode")
+		return
+	}
+
+	preview, err := c.service.PreviewDeleteNode(actorFrom(ctx), uint(id), mode)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to preview network node deletion")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, preview)
+}
+
 func (c *NetworkNodeController) GetAllNodes(ctx *gin.Context) {
-	nodes, err := c.service.GetAllNodes()
+	nodes, err := c.service.GetAllNodes(actorFrom(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get network nodes"})
+		problem.Error(ctx, err, "Failed to get network nodes")
 		return
 	}
 
+	paths := c.service.BuildBreadcrumbs(nodes)
 	response := make([]dto.NetworkNodeResponse, len(nodes))
 	for i, node := range nodes {
 		response[i] = c.service.ToNetworkNodeResponse(&node)
+		response[i].Path = paths[node.ID]
 	}
 
 	ctx.JSON(http.StatusOK, response)
 }
 
 func (c *NetworkNodeController) GetFullTree(ctx *gin.Context) {
-	tree, err := c.service.GetFullTree()
+	tree, err := c.service.GetFullTree(actorFrom(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get tree"})
+		problem.Error(ctx, err, "Failed to get tree")
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{"tree": tree})
 }
+
+func (c *NetworkNodeController) GetSubtree(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	depth := -1
+	if value := ctx.Query("depth"); value != "" {
+		if depth, err = strconv.Atoi(value); err != nil || depth < 0 {
+			problem.Respond(ctx, http.StatusBadRequest, "Invalid depth")
+			return
+		}
+	}
+
+	tree, err := c.service.GetSubtree(actorFrom(ctx), uint(id), depth)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get subtree")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, tree)
+}
+
+func (c *NetworkNodeController) GetAncestors(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid node ID")
+		return
+	}
+
+	ancestors, err := c.service.GetAncestors(actorFrom(ctx), uint(id))
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get ancestors")
+		return
+	}
+
+	paths := c.service.BuildBreadcrumbs(ancestors)
+	response := make([]dto.NetworkNodeResponse, len(ancestors))
+	for i, node := range ancestors {
+		response[i] = c.service.ToNetworkNodeResponse(&node)
+		response[i].Path = paths[node.ID]
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,60 @@
+package controller
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRequestSize limits the size of a JSON body read without gin's binding.
+const maxRequestSize = 1 << 20
+
+// MergePatchContentType is the media type of a JSON merge patch (RFC 7396).
+const MergePatchContentType = "application/merge-patch+json"
+
+// bindStrictJSON decodes the request body into req with
+// service.DecodeRequest, which rejects unknown fields. It writes the error
+// response and reports false when the body is not acceptable.
+func bindStrictJSON(ctx *gin.Context, req interface{}) bool {
+	body, ok := readBody(ctx)
+	if !ok {
+		return false
+	}
+	if err := service.DecodeRequest(body, req); err != nil {
+		problem.Error(ctx, err, "Invalid request data")
+		return false
+	}
+	return true
+}
+
+// readMergePatch reads a JSON merge patch from the request body. Plain
+// application/json is accepted as well for clients that cannot set the
+// media type.
+func readMergePatch(ctx *gin.Context) ([]byte, bool) {
+	mediaType, _, err := mime.ParseMediaType(ctx.GetHeader("Content-Type"))
+	if err != nil || (mediaType != MergePatchContentType && mediaType != gin.MIMEJSON) {
+		ctx.Header("Accept-Patch", MergePatchContentType)
+		problem.Respond(ctx, http.StatusUnsupportedMediaType, "Content-Type must be "+MergePatchContentType)
+		return nil, false
+	}
+	return readBody(ctx)
+}
+
+func readBody(ctx *gin.Context) ([]byte, bool) {
+	ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxRequestSize)
+	body, err := ctx.GetRawData()
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			problem.Respond(ctx, http.StatusRequestEntityTooLarge, "Request body is too large")
+		} else {
+			problem.Respond(ctx, http.StatusBadRequest, "Failed to read request body")
+		}
+		return nil, false
+	}
+	return body, true
+}
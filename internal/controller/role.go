@@ -0,0 +1,136 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	service *service.RoleService
+}
+
+func NewRoleController(service *service.RoleService) *RoleController {
+	return &RoleController{service: service}
+}
+
+func (c *RoleController) GetPermissions(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, models.AllPermissions)
+}
+
+func (c *RoleController) GetAllRoles(ctx *gin.Context) {
+	roles, err := c.service.GetAllRoles()
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get roles")
+		return
+	}
+
+	response := make([]dto.RoleResponse, len(roles))
+	for i, role := range roles {
+		response[i] = c.service.ToRoleResponse(&role)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *RoleController) GetRole(ctx *gin.Context) {
+	id, ok := parseRoleID(ctx)
+	if !ok {
+		return
+	}
+
+	role, err := c.service.GetRole(id)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get role")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToRoleResponse(role))
+}
+
+func (c *RoleController) CreateRole(ctx *gin.Context) {
+	var req dto.RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	role, err := c.service.CreateRole(&req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to create role")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, c.service.ToRoleResponse(role))
+}
+
+func (c *RoleController) UpdateRole(ctx *gin.Context) {
+	id, ok := parseRoleID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.RoleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	role, err := c.service.UpdateRole(id, &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update role")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToRoleResponse(role))
+}
+
+// SetRequireMFA makes a second factor mandatory or optional for the role.
+func (c *RoleController) SetRequireMFA(ctx *gin.Context) {
+	id, ok := parseRoleID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.RoleMFARequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	role, err := c.service.SetRequireMFA(id, *req.RequireMFA)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update role")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToRoleResponse(role))
+}
+
+func (c *RoleController) DeleteRole(ctx *gin.Context) {
+	id, ok := parseRoleID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.service.DeleteRole(id); err != nil {
+		problem.Error(ctx, err, "Failed to delete role")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func parseRoleID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid role ID")
+		return 0, false
+	}
+	return uint(id), true
+}
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+
+	"equipment-management/internal/problem"
+	"github.com/gin-gonic/gin"
+
+	"equipment-management/internal/service"
+)
+
+type SSOCallbackRequest struct {
+	Code  string `json:"code" binding:"required"`
+	State string `json:"state" binding:"required"`
+}
+
+type SSOStartResponse struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
+
+// SSOController runs the single sign-on login. The frontend sends the user
+// to the URL returned by Start and posts the code and state the provider
+// redirects back with to Callback. Failures are logged with their details,
+// which the client is not told about.
+type SSOController struct {
+	service *service.SSOService
+}
+
+func NewSSOController(service *service.SSOService) *SSOController {
+	return &SSOController{service: service}
+}
+
+// ssoStateCookie keeps the state of a login in the browser that started it,
+// so that the callback is only accepted from that browser.
+const ssoStateCookie = "sso_state"
+
+func (c *SSOController) Start(ctx *gin.Context) {
+	url, state, err := c.service.Start()
+	if err != nil {
+		log.Printf("Single sign-on: %v [%s]", err, ctx.GetString("requestID"))
+		problem.Error(ctx, err, "Failed to start single sign-on")
+		return
+	}
+
+	setSSOStateCookie(ctx, state, int(service.SSOStateExpiration.Seconds()))
+	ctx.JSON(http.StatusOK, SSOStartResponse{AuthorizationURL: url})
+}
+
+func (c *SSOController) Callback(ctx *gin.Context) {
+	var req SSOCallbackRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request")
+		return
+	}
+
+	browserState, _ := ctx.Cookie(ssoStateCookie)
+	setSSOStateCookie(ctx, "", -1)
+
+	tokens, challenge, err := c.service.Complete(req.Code, req.State, browserState, clientInfo(ctx))
+	if err != nil {
+		log.Printf("Single sign-on: %v [%s]", err, ctx.GetString("requestID"))
+		problem.Error(ctx, err, "Failed to generate token")
+		return
+	}
+
+	respondLogin(ctx, tokens, challenge)
+}
+
+// setSSOStateCookie stores the state for maxAge seconds, or removes it for a
+// negative maxAge. The cookie is limited to the login endpoints and not
+// readable by scripts.
+func setSSOStateCookie(ctx *gin.Context, state string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     ssoStateCookie,
+		Value:    state,
+		Path:     "/login/sso",
+		MaxAge:   maxAge,
+		Secure:   ctx.Request.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
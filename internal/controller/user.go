@@ -0,0 +1,224 @@
+package controller
+
+import (
+	"net/http"
+	"strconv"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/middleware"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
+	"github.com/gin-gonic/gin"
+)
+
+type UserController struct {
+	service *service.UserService
+}
+
+func NewUserController(service *service.UserService) *UserController {
+	return &UserController{service: service}
+}
+
+func (c *UserController) GetAllUsers(ctx *gin.Context) {
+	users, err := c.service.GetAllUsers()
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get users")
+		return
+	}
+
+	response := make([]dto.UserResponse, len(users))
+	for i, user := range users {
+		response[i] = c.service.ToUserResponse(&user)
+	}
+
+	ctx.JSON(http.StatusOK, response)
+}
+
+func (c *UserController) GetUser(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.service.GetUser(id)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get user")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToUserResponse(user))
+}
+
+func (c *UserController) GetCurrentUser(ctx *gin.Context) {
+	id, ok := middleware.CurrentUserID(ctx)
+	if !ok {
+		problem.Respond(ctx, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	user, err := c.service.GetUser(id)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to get user")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToUserResponse(user))
+}
+
+func (c *UserController) CreateUser(ctx *gin.Context) {
+	var req dto.CreateUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	user, err := c.service.CreateUser(&req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to create user")
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, c.service.ToUserResponse(user))
+}
+
+func (c *UserController) UpdateUser(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.UpdateUserRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Invalid request data")
+		return
+	}
+
+	user, err := c.service.UpdateUser(id, &req)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update user")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToUserResponse(user))
+}
+
+func (c *UserController) DisableUser(ctx *gin.Context) {
+	c.setDisabled(ctx, true)
+}
+
+func (c *UserController) EnableUser(ctx *gin.Context) {
+	c.setDisabled(ctx, false)
+}
+
+func (c *UserController) setDisabled(ctx *gin.Context, disabled bool) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	user, err := c.service.SetDisabled(id, disabled)
+	if err != nil {
+		problem.Error(ctx, err, "Failed to update user")
+		return
+	}
+
+	ctx.JSON(http.StatusOK, c.service.ToUserResponse(user))
+}
+
+func (c *UserController) DeleteUser(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.service.DeleteUser(id); err != nil {
+		problem.Error(ctx, err, "Failed to delete user")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) RevokeSessions(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.service.RevokeSessions(id); err != nil {
+		problem.Error(ctx, err, "Failed to revoke sessions")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Unlock lifts the lockout of the user's login after failed attempts.
+func (c *UserController) Unlock(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	if err := c.service.Unlock(id); err != nil {
+		problem.Error(ctx, err, "Failed to unlock user")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) ResetPassword(ctx *gin.Context) {
+	id, ok := parseUserID(ctx)
+	if !ok {
+		return
+	}
+
+	var req dto.ResetPasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "Password must be 8 to 72 characters long")
+		return
+	}
+
+	if err := c.service.ResetPassword(id, req.Password); err != nil {
+		problem.Error(ctx, err, "Failed to reset password")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func (c *UserController) ChangeOwnPassword(ctx *gin.Context) {
+	id, ok := middleware.CurrentUserID(ctx)
+	if !ok {
+		problem.Respond(ctx, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+	sessionID, ok := middleware.CurrentSessionID(ctx)
+	if !ok {
+		problem.Respond(ctx, http.StatusUnauthorized, "Invalid token")
+		return
+	}
+
+	var req dto.ChangePasswordRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		problem.Bind(ctx, err, "New password must be 8 to 72 characters long")
+		return
+	}
+
+	if err := c.service.ChangePassword(id, sessionID, &req, clientInfo(ctx)); err != nil {
+		problem.Error(ctx, err, "Failed to change password")
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
+
+func parseUserID(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		problem.Respond(ctx, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	return uint(id), true
+}
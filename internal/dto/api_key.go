@@ -0,0 +1,24 @@
+package dto
+
+import "time"
+
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name" binding:"required,max=128"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+type APIKeyResponse struct {
+	ID         uint   `json:"id"`
+	Name       string `json:"name"`
+	Prefix     string `json:"prefix"`
+	ExpiresAt  string `json:"expires_at,omitempty"`
+	LastUsedAt string `json:"last_used_at,omitempty"`
+	RevokedAt  string `json:"revoked_at,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// CreatedAPIKeyResponse carries the key itself, which is shown only once.
+type CreatedAPIKeyResponse struct {
+	APIKeyResponse
+	Key string `json:"key"`
+}
@@ -0,0 +1,36 @@
+package dto
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type AuditQuery struct {
+	EntityType string `form:"entity_type" binding:"omitempty,oneof=device network_node"`
+	EntityID   uint   `form:"entity_id"`
+	ActorID    uint   `form:"actor_id"`
+	Action     string `form:"action" binding:"omitempty,oneof=create update delete transition move copy"`
+	// Field selects events that changed the named field, e.g. network_node_id.
+	Field  string    `form:"field"`
+	Since  time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until  time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit  int       `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Cursor uint      `form:"cursor"`
+}
+
+type AuditEventResponse struct {
+	ID         uint            `json:"id"`
+	ActorID    *uint           `json:"actor_id,omitempty"`
+	ActorLogin string          `json:"actor_login,omitempty"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   uint            `json:"entity_id"`
+	Changes    json.RawMessage `json:"changes"`
+	RequestID  string          `json:"request_id,omitempty"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+type AuditListResponse struct {
+	Items      []AuditEventResponse `json:"items"`
+	NextCursor uint                 `json:"next_cursor,omitempty"`
+}
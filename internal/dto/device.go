@@ -6,28 +6,83 @@ type CreateDeviceRequest struct {
 	Model         string `json:"model" binding:"required"`
 	Serial        string `json:"serial" binding:"required"`
 	Location      string `json:"location" binding:"required"`
+	Status        string `json:"status" binding:"omitempty,oneof=ordered in_stock active"`
 	NetworkNodeID *uint  `json:"network_node_id"`
 }
 
+// UpdateDeviceRequest replaces all editable fields of a device: omitted
+// optional fields are cleared.
 type UpdateDeviceRequest struct {
-	Type          string `json:"type"`
-	Vendor        string `json:"vendor"`
-	Model         string `json:"model"`
-	Serial        string `json:"serial"`
+	Type          string `json:"type" binding:"required"`
+	Vendor        string `json:"vendor" binding:"required"`
+	Model         string `json:"model" binding:"required"`
+	Serial        string `json:"serial" binding:"required"`
 	Location      string `json:"location"`
-	Status        string `json:"status"`
 	NetworkNodeID *uint  `json:"network_node_id"`
 }
 
 type DeviceResponse struct {
-	ID            uint   `json:"id"`
-	Type          string `json:"type"`
-	Vendor        string `json:"vendor,omitempty"`
-	Model         string `json:"model"`
-	Serial        string `json:"serial,omitempty"`
-	Location      string `json:"location,omitempty"`
-	Status        string `json:"status"`
-	NetworkNodeID *uint  `json:"network_node_id,omitempty"`
-	CreatedAt     string `json:"created_at,omitempty"`
-	UpdatedAt     string `json:"updated_at,omitempty"`
+	ID              uint   `json:"id"`
+	Type            string `json:"type"`
+	Vendor          string `json:"vendor,omitempty"`
+	Model           string `json:"model"`
+	Serial          string `json:"serial,omitempty"`
+	Location        string `json:"location,omitempty"`
+	Status          string `json:"status"`
+	NetworkNodeID   *uint  `json:"network_node_id,omitempty"`
+	NetworkNodePath string `json:"network_node_path,omitempty"`
+	Version         uint   `json:"version,omitempty"`
+	CreatedAt       string `json:"created_at,omitempty"`
+	UpdatedAt       string `json:"updated_at,omitempty"`
+}
+
+type DeviceListQuery struct {
+	Type     string `form:"type"`
+	Vendor   string `form:"vendor"`
+	Model    string `form:"model"`
+	Status   string `form:"status"`
+	Location string `form:"location"`
+	// NetworkNodeID is a node ID or "unassigned" for devices without a node.
+	NetworkNodeID string `form:"network_node_id"`
+	Search        string `form:"q"`
+	// Sort is a column name, prefixed with "-" for descending order.
+	Sort   string `form:"sort"`
+	Limit  int    `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Cursor string `form:"cursor"`
+}
+
+type DeviceListResponse struct {
+	Items      []DeviceResponse `json:"items"`
+	Total      int64            `json:"total"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type DeviceTransitionRequest struct {
+	Status string `json:"status" binding:"required"`
+	Reason string `json:"reason"`
+}
+
+type DeviceTransitionResponse struct {
+	ID         uint   `json:"id"`
+	DeviceID   uint   `json:"device_id"`
+	FromStatus string `json:"from_status"`
+	ToStatus   string `json:"to_status"`
+	Reason     string `json:"reason,omitempty"`
+	CreatedAt  string `json:"created_at"`
+}
+
+type DeviceImportRow struct {
+	// Line is the line of the row in the CSV file, the header being line 1.
+	Line     int      `json:"line"`
+	Serial   string   `json:"serial,omitempty"`
+	DeviceID *uint    `json:"device_id,omitempty"`
+	Errors   []string `json:"errors,omitempty"`
+}
+
+type DeviceImportResponse struct {
+	DryRun  bool              `json:"dry_run"`
+	Valid   bool              `json:"valid"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Rows    []DeviceImportRow `json:"rows"`
 }
@@ -0,0 +1,30 @@
+package dto
+
+import "time"
+
+type LoginAttemptQuery struct {
+	Login   string    `form:"login"`
+	UserID  uint      `form:"user_id"`
+	IP      string    `form:"ip"`
+	Success *bool     `form:"success"`
+	Since   time.Time `form:"since" time_format:"2006-01-02T15:04:05Z07:00"`
+	Until   time.Time `form:"until" time_format:"2006-01-02T15:04:05Z07:00"`
+	Limit   int       `form:"limit" binding:"omitempty,min=1,max=1000"`
+	Cursor  uint      `form:"cursor"`
+}
+
+type LoginAttemptResponse struct {
+	ID        uint   `json:"id"`
+	Login     string `json:"login"`
+	UserID    *uint  `json:"user_id,omitempty"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Success   bool   `json:"success"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+type LoginAttemptListResponse struct {
+	Items      []LoginAttemptResponse `json:"items"`
+	NextCursor uint                   `json:"next_cursor,omitempty"`
+}
@@ -6,19 +6,89 @@ type CreateNetworkNodeRequest struct {
 	ParentID    *uint  `json:"parent_id"`
 }
 
+// UpdateNetworkNodeRequest replaces all editable fields of a node: an
+// omitted description is cleared and an omitted parent makes it a root.
 type UpdateNetworkNodeRequest struct {
-	Name        string `json:"name"`
+	Name        string `json:"name" binding:"required"`
 	Description string `json:"description"`
 	ParentID    *uint  `json:"parent_id"`
 }
 
+// MoveNetworkNodeRequest attaches a node to another parent; an omitted
+// parent makes it a root. Position is its index among the new siblings and
+// places it last when omitted.
+type MoveNetworkNodeRequest struct {
+	ParentID *uint `json:"parent_id"`
+	Position *int  `json:"position" binding:"omitempty,min=0"`
+}
+
+// CopyNetworkNodeRequest clones the branch of a node under a parent; an
+// omitted parent makes the copy a root. An empty name keeps the name of the
+// node.
+type CopyNetworkNodeRequest struct {
+	ParentID       *uint  `json:"parent_id"`
+	Name           string `json:"name"`
+	WithoutDevices bool   `json:"without_devices"`
+}
+
+// ReorderNetworkNodesRequest lists the children of a parent, or the roots
+// when the parent is omitted, in their new order.
+type ReorderNetworkNodesRequest struct {
+	ParentID *uint  `json:"parent_id"`
+	IDs      []uint `json:"ids" binding:"required"`
+}
+
+// CopyNetworkNodeResponse is the copy of a node, along with the devices
+// copied with its branch.
+type CopyNetworkNodeResponse struct {
+	NetworkNodeResponse
+	CopiedDevices []CopiedDeviceResponse `json:"copied_devices"`
+}
+
+// CopiedDeviceResponse is a device created by copying a branch. Serials are
+// unique, so its serial is that of the original suffixed with "-" and the
+// ID of its new node, e.g. "SN-1-42", and it starts as ordered whatever the
+// status of the original.
+type CopiedDeviceResponse struct {
+	ID            uint   `json:"id"`
+	SourceID      uint   `json:"source_id"`
+	Serial        string `json:"serial"`
+	Status        string `json:"status"`
+	NetworkNodeID uint   `json:"network_node_id"`
+}
+
 type NetworkNodeResponse struct {
 	ID          uint                  `json:"id"`
 	Name        string                `json:"name"`
 	Description string                `json:"description"`
 	ParentID    *uint                 `json:"parent_id,omitempty"`
+	Path        string                `json:"path,omitempty"`
 	Children    []NetworkNodeResponse `json:"children,omitempty"`
 	Devices     []DeviceResponse      `json:"devices,omitempty"`
+	Position    int                   `json:"position"`
+	Version     uint                  `json:"version,omitempty"`
 	CreatedAt   string                `json:"created_at,omitempty"`
 	UpdatedAt   string                `json:"updated_at,omitempty"`
 }
+
+// DeletePreviewResponse lists what deleting a node in the given mode would
+// change. Allowed is false when the node is not empty in refuse mode.
+type DeletePreviewResponse struct {
+	Mode        string                `json:"mode"`
+	Allowed     bool                  `json:"allowed"`
+	NewParentID *uint                 `json:"new_parent_id"`
+	Deleted     []NetworkNodeResponse `json:"deleted"`
+	Moved       []NetworkNodeResponse `json:"moved"`
+	Devices     []DeletePreviewDevice `json:"devices"`
+}
+
+// DeletePreviewDevice is a device affected by deleting a node. Action is
+// "move", "unassign" or "decommission".
+type DeletePreviewDevice struct {
+	ID     uint   `json:"id"`
+	Type   string `json:"type"`
+	Model  string `json:"model"`
+	Serial string `json:"serial"`
+	Status string `json:"status"`
+	Action string `json:"action"`
+}
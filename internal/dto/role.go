@@ -0,0 +1,20 @@
+package dto
+
+type RoleRequest struct {
+	Name        string   `json:"name" binding:"required,max=64"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleResponse struct {
+	ID          uint     `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Builtin     bool     `json:"builtin"`
+	RequireMFA  bool     `json:"require_mfa"`
+	Permissions []string `json:"permissions"`
+}
+
+type RoleMFARequest struct {
+	RequireMFA *bool `json:"require_mfa" binding:"required"`
+}
@@ -1,9 +1,11 @@
 package dto
 
 type TreeNode struct {
-	ID          uint       `json:"id"`
-	Name        string     `json:"name"`
-	Description string     `json:"description,omitempty"`
-	Type        string     `json:"type"`
-	Children    []TreeNode `json:"children,omitempty"`
+	ID          uint   `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Path is the breadcrumb of a node; devices have none.
+	Path     string     `json:"path,omitempty"`
+	Type     string     `json:"type"`
+	Children []TreeNode `json:"children,omitempty"`
 }
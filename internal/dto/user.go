@@ -0,0 +1,42 @@
+package dto
+
+type CreateUserRequest struct {
+	Login string `json:"login" binding:"required,max=64"`
+	// Password is required for everyone but service accounts, which have
+	// none.
+	Password string `json:"password" binding:"omitempty,min=8,max=72"`
+	Role     string `json:"role" binding:"required,max=64"`
+	// ScopeNodeID restricts the role to the subtree rooted at this node.
+	ScopeNodeID *uint `json:"scope_node_id"`
+	// ServiceAccount creates a user for scripts that authenticates with API
+	// keys only.
+	ServiceAccount bool `json:"service_account"`
+}
+
+type UpdateUserRequest struct {
+	Login string `json:"login" binding:"omitempty,max=64"`
+	Role  string `json:"role" binding:"omitempty,max=64"`
+	// ScopeNodeID replaces the scope of the user; 0 removes it.
+	ScopeNodeID *uint `json:"scope_node_id"`
+}
+
+type ResetPasswordRequest struct {
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=8,max=72"`
+}
+
+type UserResponse struct {
+	ID             uint   `json:"id"`
+	Login          string `json:"login"`
+	Role           string `json:"role"`
+	ScopeNodeID    *uint  `json:"scope_node_id"`
+	ServiceAccount bool   `json:"service_account"`
+	AuthSource     string `json:"auth_source"`
+	Disabled       bool   `json:"disabled"`
+	CreatedAt      string `json:"created_at,omitempty"`
+	UpdatedAt      string `json:"updated_at,omitempty"`
+}
@@ -4,53 +4,126 @@ import (
 	"net/http"
 	"strings"
 
-	"equipment-management/internal/config"
+	"equipment-management/internal/models"
+	"equipment-management/internal/problem"
+	"equipment-management/internal/service"
 	"equipment-management/pkg/auth"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// APIKeyHeader carries the API key of a service account. A key may also be
+// sent as a Bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// AuthMiddleware accepts either a JWT access token or an API key.
+func AuthMiddleware(authService *service.AuthService, apiKeys *service.APIKeyService) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		cfg := config.LoadConfig()
+		token := c.GetHeader(APIKeyHeader)
+		if token == "" {
+			authHeader := c.GetHeader("Authorization")
+			if authHeader == "" {
+				problem.Abort(c, http.StatusUnauthorized, "Authorization header required")
+				return
+			}
 
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authorization header required"})
-			return
+			tokenParts := strings.Split(authHeader, " ")
+			if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
+				problem.Abort(c, http.StatusUnauthorized, "Invalid authorization format")
+				return
+			}
+			token = tokenParts[1]
 		}
 
-		tokenParts := strings.Split(authHeader, " ")
-		if len(tokenParts) != 2 || tokenParts[0] != "Bearer" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid authorization format"})
-			return
+		var principal *service.Principal
+		var err error
+		if c.GetHeader(APIKeyHeader) != "" || auth.IsAPIKey(token) {
+			principal, err = apiKeys.Authenticate(token)
+		} else {
+			principal, err = authService.Authenticate(token)
 		}
-
-		claims, err := auth.ParseJWT(tokenParts[1], cfg.JWTSecret)
 		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			problem.AbortError(c, err, "Failed to verify token")
 			return
 		}
 
-		c.Set("userID", claims["sub"])
-		c.Set("userRole", claims["role"])
+		c.Set("userID", principal.UserID)
+		c.Set("userRole", principal.Role)
+		// Requests made with an API key belong to no session.
+		if principal.SessionID != 0 {
+			c.Set("sessionID", principal.SessionID)
+		}
+		if principal.ScopeNodeID != nil {
+			c.Set("scopeNodeID", *principal.ScopeNodeID)
+		}
 
 		c.Next()
 	}
 }
 
-func RoleMiddleware(requiredRole string) gin.HandlerFunc {
+// PermissionMiddleware lets the request through only when the role of the
+// authenticated user grants permission. Users restricted to a subtree are
+// refused the permissions that concern the whole installation.
+func PermissionMiddleware(roles *service.RoleService, permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		userRole, exists := c.Get("userRole")
-		if !exists {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Role information missing"})
+		if _, exists := c.Get("userRole"); !exists {
+			problem.Abort(c, http.StatusForbidden, "Role information missing")
 			return
 		}
 
-		if userRole != requiredRole {
-			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		allowed, err := HasPermission(c, roles, permission)
+		if err != nil {
+			problem.AbortError(c, err, "Failed to check permissions")
+			return
+		}
+		if !allowed {
+			problem.Abort(c, http.StatusForbidden, "Insufficient permissions")
 			return
 		}
 
 		c.Next()
 	}
 }
+
+// HasPermission reports whether the authenticated user of the request holds
+// permission, for handlers whose permissions depend on the request body.
+func HasPermission(c *gin.Context, roles *service.RoleService, permission string) (bool, error) {
+	userRole, exists := c.Get("userRole")
+	if !exists {
+		return false, nil
+	}
+	if _, scoped := CurrentScope(c); scoped && !models.IsSubtreePermission(permission) {
+		return false, nil
+	}
+	return roles.HasPermission(userRole.(string), permission)
+}
+
+// CurrentUserID returns the ID of the authenticated user of the request.
+func CurrentUserID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("userID")
+	if !exists {
+		return 0, false
+	}
+	id, ok := value.(uint)
+	return id, ok
+}
+
+// CurrentSessionID returns the login session the request was made in.
+func CurrentSessionID(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("sessionID")
+	if !exists {
+		return 0, false
+	}
+	id, ok := value.(uint)
+	return id, ok
+}
+
+// CurrentScope returns the root of the subtree the authenticated user is
+// restricted to, if any.
+func CurrentScope(c *gin.Context) (uint, bool) {
+	value, exists := c.Get("scopeNodeID")
+	if !exists {
+		return 0, false
+	}
+	id, ok := value.(uint)
+	return id, ok
+}
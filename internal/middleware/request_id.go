@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength limits the IDs taken from clients.
+const maxRequestIDLength = 128
+
+// RequestIDMiddleware tags every request with an ID, taken from the
+// X-Request-ID header when the client sends a valid one, and echoes it
+// back.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID(requestID) {
+			buf := make([]byte, 16)
+			_, _ = rand.Read(buf)
+			requestID = hex.EncodeToString(buf)
+		}
+
+		c.Set("requestID", requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+	}
+}
+
+// validRequestID reports whether a client's request ID is safe to put in
+// logs and audit records: letters, digits, dots, underscores and hyphens
+// only, so that it can neither forge log lines nor smuggle markup.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -3,12 +3,203 @@ package models
 import "time"
 
 type User struct {
+	ID       uint   `gorm:"primaryKey"`
+	Login    string `gorm:"unique;not null"`
+	Password string `gorm:"not null"`
+	Role     string `gorm:"not null;default:'viewer'"`
+	Disabled bool   `gorm:"not null;default:false"`
+	// ServiceAccount marks a user for scripts, which has no password and
+	// authenticates with API keys only.
+	ServiceAccount bool `gorm:"not null;default:false"`
+	// AuthSource tells where the password of the user is checked. Users of
+	// an external directory have no local password.
+	AuthSource string `gorm:"not null;default:'local'"`
+	// OIDCIssuer and OIDCSubject identify the account of a single sign-on
+	// user at the provider. Unlike the login, which only shows a claim the
+	// user may be able to change there, they stay the same for the account.
+	OIDCIssuer  *string `gorm:"uniqueIndex:idx_users_oidc_account"`
+	OIDCSubject *string `gorm:"uniqueIndex:idx_users_oidc_account"`
+	// ScopeNodeID restricts the role of the user to the subtree rooted at
+	// this node. Users without a scope hold their role everywhere.
+	ScopeNodeID *uint `gorm:"index"`
+	// TOTPSecret is set once the user starts enrolling an authenticator app;
+	// TOTPEnabled once the enrollment is confirmed with a code.
+	TOTPSecret  string
+	TOTPEnabled bool `gorm:"not null;default:false"`
+	// TOTPLastStep is the time step of the last accepted code, which cannot
+	// be used again.
+	TOTPLastStep int64
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// Sources of user credentials.
+const (
+	AuthSourceLocal = "local"
+	AuthSourceLDAP  = "ldap"
+	AuthSourceOIDC  = "oidc"
+)
+
+// APIKey authenticates a service account. Only the hash of the key is
+// stored; Prefix keeps its first characters so it can be recognised.
+type APIKey struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"not null;index"`
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null"`
+	KeyHash    string `gorm:"not null;uniqueIndex"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// RecoveryCode is a one-time code that stands in for a TOTP code when the
+// authenticator app is not at hand. Only its hash is stored.
+type RecoveryCode struct {
 	ID        uint   `gorm:"primaryKey"`
-	Login     string `gorm:"unique;not null"`
-	Password  string `gorm:"not null"`
-	Role      string `gorm:"not null;default:'viewer'"`
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"not null"`
+	UsedAt    *time.Time
 	CreatedAt time.Time
-	UpdatedAt time.Time
+}
+
+// Built-in roles. Other roles may be defined at runtime.
+const (
+	RoleAdmin  = "admin"
+	RoleViewer = "viewer"
+)
+
+// Role bundles a set of permissions under a name that users refer to.
+// Builtin roles are created at startup and cannot be deleted.
+type Role struct {
+	ID          uint   `gorm:"primaryKey"`
+	Name        string `gorm:"unique;not null"`
+	Description string
+	Builtin     bool `gorm:"not null;default:false"`
+	// RequireMFA makes a second factor mandatory for users of the role.
+	RequireMFA  bool             `gorm:"not null;default:false"`
+	Permissions []RolePermission `gorm:"foreignKey:RoleID;constraint:OnDelete:CASCADE"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type RolePermission struct {
+	RoleID     uint   `gorm:"primaryKey"`
+	Permission string `gorm:"primaryKey"`
+}
+
+// Permissions checked by the API.
+const (
+	PermDeviceRead       = "device:read"
+	PermDeviceCreate     = "device:create"
+	PermDeviceUpdate     = "device:update"
+	PermDeviceDelete     = "device:delete"
+	PermDeviceTransition = "device:transition"
+	PermNodeRead         = "node:read"
+	PermNodeCreate       = "node:create"
+	PermNodeUpdate       = "node:update"
+	PermNodeDelete       = "node:delete"
+	PermAuditRead        = "audit:read"
+	PermUserManage       = "user:manage"
+	PermRoleManage       = "role:manage"
+)
+
+// AllPermissions lists every permission, in the order they are presented.
+var AllPermissions = []string{
+	PermDeviceRead,
+	PermDeviceCreate,
+	PermDeviceUpdate,
+	PermDeviceDelete,
+	PermDeviceTransition,
+	PermNodeRead,
+	PermNodeCreate,
+	PermNodeUpdate,
+	PermNodeDelete,
+	PermAuditRead,
+	PermUserManage,
+	PermRoleManage,
+}
+
+// subtreePermissions are the permissions that a user restricted to a
+// subtree may hold. The others concern the whole installation.
+var subtreePermissions = map[string]bool{
+	PermDeviceRead:       true,
+	PermDeviceCreate:     true,
+	PermDeviceUpdate:     true,
+	PermDeviceDelete:     true,
+	PermDeviceTransition: true,
+	PermNodeRead:         true,
+	PermNodeCreate:       true,
+	PermNodeUpdate:       true,
+	PermNodeDelete:       true,
+}
+
+// IsSubtreePermission reports whether permission can be granted within a
+// subtree of the network.
+func IsSubtreePermission(permission string) bool {
+	return subtreePermissions[permission]
+}
+
+// LoginAttempt records a single attempt to log in, successful or not, for
+// security review.
+type LoginAttempt struct {
+	ID        uint   `gorm:"primaryKey"`
+	Login     string `gorm:"not null;index"`
+	UserID    *uint  `gorm:"index"`
+	IP        string `gorm:"index"`
+	UserAgent string
+	Success   bool `gorm:"not null"`
+	// Reason tells why a failed attempt was rejected.
+	Reason    string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// Reasons of failed login attempts.
+const (
+	LoginFailureInvalidCredentials = "invalid_credentials"
+	LoginFailureAccountDisabled    = "account_disabled"
+	LoginFailureThrottled          = "throttled"
+	LoginFailureInvalidMFACode     = "invalid_mfa_code"
+	LoginFailureNoRole             = "no_role"
+	// LoginFailureWrongPassword is a wrong current password given to
+	// change the password in a session.
+	LoginFailureWrongPassword = "wrong_current_password"
+)
+
+// LoginThrottle counts the recent failed logins for a key, which names
+// either a login or a client IP.
+type LoginThrottle struct {
+	Key           string    `gorm:"primaryKey"`
+	Failures      int       `gorm:"not null"`
+	LastFailureAt time.Time `gorm:"not null"`
+	LockedUntil   *time.Time
+}
+
+// SSOState remembers a single sign-on login between sending the user to the
+// identity provider and the provider sending them back with a code.
+type SSOState struct {
+	State        string    `gorm:"primaryKey"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"`
+	ExpiresAt    time.Time `gorm:"not null;index"`
+	CreatedAt    time.Time
+}
+
+// Session is a login of a user. It holds the hash of the current refresh
+// token; access tokens reference the session and stop working once it is
+// revoked.
+type Session struct {
+	ID                uint   `gorm:"primaryKey"`
+	UserID            uint   `gorm:"not null;index"`
+	RefreshTokenHash  string `gorm:"not null;uniqueIndex"`
+	PreviousTokenHash string `gorm:"index"`
+	UserAgent         string
+	IP                string
+	ExpiresAt         time.Time `gorm:"not null"`
+	RevokedAt         *time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
 }
 
 type Device struct {
@@ -21,8 +212,33 @@ type Device struct {
 	Status        string `gorm:"default:'active'"`
 	NetworkNodeID *uint
 	NetworkNode   *NetworkNode `gorm:"foreignKey:NetworkNodeID"`
-	CreatedAt     time.Time
-	UpdatedAt     time.Time
+	// Version is increased by every change, so that a client can tell
+	// whether the device changed since it read it.
+	Version   uint `gorm:"not null;default:1"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Device lifecycle statuses, in the order a device normally goes through them.
+const (
+	DeviceStatusOrdered        = "ordered"
+	DeviceStatusInStock        = "in_stock"
+	DeviceStatusActive         = "active"
+	DeviceStatusInRepair       = "in_repair"
+	DeviceStatusDecommissioned = "decommissioned"
+	DeviceStatusDisposed       = "disposed"
+)
+
+// DeviceStatusTransition records one change of a device's lifecycle status.
+type DeviceStatusTransition struct {
+	ID uint `gorm:"primaryKey"`
+	// DeviceID is cleared when the device is deleted; the transitions are
+	// kept, and the audit event of the deletion lists them.
+	DeviceID   *uint  `gorm:"index"`
+	FromStatus string `gorm:"not null"`
+	ToStatus   string `gorm:"not null"`
+	Reason     string
+	CreatedAt  time.Time
 }
 
 type NetworkNode struct {
@@ -30,8 +246,41 @@ type NetworkNode struct {
 	Name        string `gorm:"not null"`
 	Description string
 	ParentID    *uint
-	Children    []NetworkNode `gorm:"foreignkey:ParentID"`
-	Devices     []Device      `gorm:"foreignkey:NetworkNodeID"`
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	// The foreign keys of both relations are maintained by
+	// repository.MigrateForeignKeys rather than AutoMigrate, which would
+	// add them validated and fail on rows pointing at deleted nodes.
+	Children []NetworkNode `gorm:"foreignkey:ParentID;constraint:-"`
+	Devices  []Device      `gorm:"foreignkey:NetworkNodeID;constraint:-"`
+	// Position orders the node among its siblings.
+	Position int `gorm:"not null;default:0"`
+	// Version is increased by every change of the node itself.
+	Version   uint `gorm:"not null;default:1"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Audit event actions and entity types.
+const (
+	AuditActionCreate     = "create"
+	AuditActionUpdate     = "update"
+	AuditActionDelete     = "delete"
+	AuditActionTransition = "transition"
+	AuditActionMove       = "move"
+	AuditActionCopy       = "copy"
+
+	AuditEntityDevice      = "device"
+	AuditEntityNetworkNode = "network_node"
+)
+
+// AuditEvent records a single change of a device or a network node. Changes
+// maps every changed field to its values before and after the change.
+type AuditEvent struct {
+	ID         uint   `gorm:"primaryKey"`
+	ActorID    *uint  `gorm:"index"`
+	Action     string `gorm:"not null"`
+	EntityType string `gorm:"not null;index:idx_audit_events_entity"`
+	EntityID   uint   `gorm:"not null;index:idx_audit_events_entity"`
+	Changes    string `gorm:"type:jsonb;not null"`
+	RequestID  string
+	CreatedAt  time.Time `gorm:"index"`
 }
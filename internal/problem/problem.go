@@ -0,0 +1,128 @@
+// Package problem writes error responses as RFC 7807 problem details.
+package problem
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// ContentType is the media type of a problem details response.
+const ContentType = "application/problem+json"
+
+var statuses = map[apperror.Kind]int{
+	apperror.Internal:           http.StatusInternalServerError,
+	apperror.BadRequest:         http.StatusBadRequest,
+	apperror.Validation:         http.StatusUnprocessableEntity,
+	apperror.Unauthorized:       http.StatusUnauthorized,
+	apperror.Forbidden:          http.StatusForbidden,
+	apperror.NotFound:           http.StatusNotFound,
+	apperror.Conflict:           http.StatusConflict,
+	apperror.PreconditionFailed: http.StatusPreconditionFailed,
+	apperror.TooManyRequests:    http.StatusTooManyRequests,
+	apperror.Unavailable:        http.StatusServiceUnavailable,
+}
+
+// Status returns the HTTP status of errors of kind.
+func Status(kind apperror.Kind) int {
+	return statuses[kind]
+}
+
+// Respond writes a problem with the given status and detail.
+func Respond(ctx *gin.Context, status int, detail string, fields ...apperror.FieldError) {
+	ctx.Render(status, render(ctx, status, detail, fields))
+}
+
+// Abort is Respond for middleware: it also stops the handler chain.
+func Abort(ctx *gin.Context, status int, detail string) {
+	ctx.Abort()
+	Respond(ctx, status, detail)
+}
+
+// retrier is implemented by errors that know when the request may be made
+// again, such as a throttled login.
+type retrier interface {
+	RetryIn() time.Duration
+}
+
+// Error writes the problem for err according to its kind. Internal errors
+// are logged and reported with the fallback detail only, so that they do
+// not leak internals to the client. A request body over its size limit and
+// a record gorm did not find are recognised without a kind.
+func Error(ctx *gin.Context, err error, fallback string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Respond(ctx, http.StatusRequestEntityTooLarge, "Request body is too large")
+		return
+	}
+
+	kind := apperror.KindOf(err)
+	if kind == apperror.Internal && errors.Is(err, gorm.ErrRecordNotFound) {
+		Respond(ctx, http.StatusNotFound, "Not found")
+		return
+	}
+	if kind == apperror.Internal {
+		log.Printf("request %s: %s: %v", ctx.GetString("requestID"), fallback, err)
+		Respond(ctx, http.StatusInternalServerError, fallback)
+		return
+	}
+
+	var retry retrier
+	if errors.As(err, &retry) {
+		ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retry.RetryIn().Seconds()))))
+	}
+
+	detail := apperror.Detail(err)
+	if detail == "" {
+		detail = fallback
+	}
+	Respond(ctx, Status(kind), detail, apperror.FieldsOf(err)...)
+}
+
+// AbortError is Error for middleware: it also stops the handler chain.
+func AbortError(ctx *gin.Context, err error, fallback string) {
+	ctx.Abort()
+	Error(ctx, err, fallback)
+}
+
+// Bind writes the problem for a request that gin failed to bind: the
+// offending fields for a validation failure, and detail alone for a body
+// that could not be decoded.
+func Bind(ctx *gin.Context, err error, detail string) {
+	Error(ctx, apperror.FromBinding(detail, err), detail)
+}
+
+func render(ctx *gin.Context, status int, detail string, fields []apperror.FieldError) problemRender {
+	return problemRender{dto.Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  ctx.Request.URL.Path,
+		RequestID: ctx.GetString("requestID"),
+		Errors:    fields,
+	}}
+}
+
+// problemRender is gin's JSON renderer with the problem media type.
+type problemRender struct {
+	problem dto.Problem
+}
+
+func (r problemRender) Render(w http.ResponseWriter) error {
+	r.WriteContentType(w)
+	return json.NewEncoder(w).Encode(r.problem)
+}
+
+func (r problemRender) WriteContentType(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(key *models.APIKey) error {
+	return r.db.Create(key).Error
+}
+
+func (r *APIKeyRepository) GetByHash(hash string) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Where("key_hash = ?", hash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetForUser returns the key with the given id if it belongs to the user.
+func (r *APIKeyRepository) GetForUser(userID, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+	if err := r.db.Where("user_id = ?", userID).First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) ListForUser(userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *APIKeyRepository) Revoke(key *models.APIKey) error {
+	return r.db.Model(key).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error
+}
+
+// TouchLastUsed records that the key was used at now, unless its last use
+// was recorded after since already.
+func (r *APIKeyRepository) TouchLastUsed(id uint, now, since time.Time) error {
+	return r.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, since).
+		Update("last_used_at", now).Error
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"encoding/json"
+	"equipment-management/internal/models"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actor identifies who performs a change and within which request, so the
+// change can be attributed in the audit log.
+type Actor struct {
+	UserID    *uint
+	RequestID string
+	// Scope is the root of the subtree the actor is restricted to; nil
+	// means the actor is not restricted.
+	Scope *uint
+}
+
+// FieldChange is the value of a single field before and after a change.
+type FieldChange struct {
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+type AuditRepository struct {
+	db *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+// AuditRecord is an audit event together with the login of its actor.
+type AuditRecord struct {
+	models.AuditEvent
+	ActorLogin string
+}
+
+// AuditFilter narrows an audit log listing. Zero fields do not restrict it.
+type AuditFilter struct {
+	EntityType string
+	EntityID   uint
+	ActorID    uint
+	Action     string
+	// Field selects events that changed the named field.
+	Field  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Before uint
+}
+
+// List returns audit events matching filter, newest first, with IDs below
+// filter.Before when it is set.
+func (r *AuditRepository) List(filter AuditFilter) ([]AuditRecord, error) {
+	query := r.db.Table("audit_events").
+		Select("audit_events.*, users.login AS actor_login").
+		Joins("LEFT JOIN users ON users.id = audit_events.actor_id")
+
+	if filter.EntityType != "" {
+		query = query.Where("audit_events.entity_type = ?", filter.EntityType)
+	}
+	if filter.EntityID != 0 {
+		query = query.Where("audit_events.entity_id = ?", filter.EntityID)
+	}
+	if filter.ActorID != 0 {
+		query = query.Where("audit_events.actor_id = ?", filter.ActorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("audit_events.action = ?", filter.Action)
+	}
+	if filter.Field != "" {
+		query = query.Where("jsonb_exists(audit_events.changes, ?)", filter.Field)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("audit_events.created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("audit_events.created_at < ?", filter.Until)
+	}
+	if filter.Before != 0 {
+		query = query.Where("audit_events.id < ?", filter.Before)
+	}
+
+	var records []AuditRecord
+	if err := query.Order("audit_events.id DESC").Limit(filter.Limit).Scan(&records).Error; err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// recordAudit stores an event describing the change of an entity from
+// before to after. A nil before denotes creation and a nil after deletion.
+// Updates that leave every field unchanged are not recorded.
+func recordAudit(tx *gorm.DB, actor Actor, action, entityType string, entityID uint, before, after map[string]interface{}) error {
+	changes := diffSnapshots(before, after)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.AuditEvent{
+		ActorID:    actor.UserID,
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Changes:    string(data),
+		RequestID:  actor.RequestID,
+	}).Error
+}
+
+func diffSnapshots(before, after map[string]interface{}) map[string]FieldChange {
+	changes := make(map[string]FieldChange)
+	for field, from := range before {
+		to, ok := after[field]
+		if !ok || !reflect.DeepEqual(from, to) {
+			changes[field] = FieldChange{From: from, To: to}
+		}
+	}
+	for field, to := range after {
+		if _, ok := before[field]; !ok {
+			changes[field] = FieldChange{From: nil, To: to}
+		}
+	}
+	return changes
+}
+
+func deviceSnapshot(device *models.Device) map[string]interface{} {
+	return map[string]interface{}{
+		"type":            device.Type,
+		"vendor":          device.Vendor,
+		"model":           device.Model,
+		"serial":          device.Serial,
+		"location":        device.Location,
+		"status":          device.Status,
+		"network_node_id": optionalID(device.NetworkNodeID),
+	}
+}
+
+// transitionsSnapshot lists the status history of a device, oldest first.
+func transitionsSnapshot(transitions []models.DeviceStatusTransition) []map[string]interface{} {
+	snapshot := make([]map[string]interface{}, len(transitions))
+	for i, transition := range transitions {
+		snapshot[i] = map[string]interface{}{
+			"from_status": transition.FromStatus,
+			"to_status":   transition.ToStatus,
+			"reason":      transition.Reason,
+			"created_at":  transition.CreatedAt.Format(time.RFC3339),
+		}
+	}
+	return snapshot
+}
+
+func networkNodeSnapshot(node *models.NetworkNode) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        node.Name,
+		"description": node.Description,
+		"parent_id":   optionalID(node.ParentID),
+	}
+}
+
+func optionalID(id *uint) interface{} {
+	if id == nil {
+		return nil
+	}
+	return *id
+}
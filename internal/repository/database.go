@@ -21,6 +21,9 @@ func InitDB(cfg *config.Config) error {
 	if err != nil {
 		return fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if err := DB.Use(errorTranslation{}); err != nil {
+		return fmt.Errorf("failed to register error translation: %w", err)
+	}
 
 	log.Println("Database connection established")
 	return nil
@@ -1,10 +1,18 @@
 package repository
 
 import (
+	"equipment-management/internal/apperror"
 	"equipment-management/internal/models"
+	"errors"
+	"fmt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// ErrDeviceNotFound is returned for a device that does not exist, or that
+// the actor is not to know about.
+var ErrDeviceNotFound = apperror.Wrap(apperror.NotFound, "device not found", gorm.ErrRecordNotFound)
+
 type DeviceRepository struct {
 	db *gorm.DB
 }
@@ -13,8 +21,40 @@ func NewDeviceRepository(db *gorm.DB) *DeviceRepository {
 	return &DeviceRepository{db: db}
 }
 
-func (r *DeviceRepository) Create(device *models.Device) error {
-	return r.db.Create(device).Error
+func (r *DeviceRepository) Create(actor Actor, device *models.Device) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(device).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, models.AuditActionCreate, models.AuditEntityDevice, device.ID, nil, deviceSnapshot(device))
+	})
+}
+
+// CreateBatch creates all devices in a single transaction.
+func (r *DeviceRepository) CreateBatch(actor Actor, devices []models.Device) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.CreateInBatches(devices, 500).Error; err != nil {
+			return err
+		}
+		for i := range devices {
+			if err := recordAudit(tx, actor, models.AuditActionCreate, models.AuditEntityDevice, devices[i].ID, nil, deviceSnapshot(&devices[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// ExistingSerials returns those of serials that are already taken.
+func (r *DeviceRepository) ExistingSerials(serials []string) ([]string, error) {
+	var existing []string
+	if len(serials) == 0 {
+		return existing, nil
+	}
+	if err := r.db.Model(&models.Device{}).Where("serial IN ?", serials).Pluck("serial", &existing).Error; err != nil {
+		return nil, err
+	}
+	return existing, nil
 }
 
 func (r *DeviceRepository) GetByID(id uint) (*models.Device, error) {
@@ -25,27 +65,179 @@ func (r *DeviceRepository) GetByID(id uint) (*models.Device, error) {
 	return &device, nil
 }
 
-func (r *DeviceRepository) Update(id uint, updateData *models.Device) (*models.Device, error) {
+// Update replaces the editable fields of the device with those of
+// updateData, zero values included, provided it is still at version; a
+// version of 0 skips the check.
+func (r *DeviceRepository) Update(actor Actor, id, version uint, updateData *models.Device) (*models.Device, error) {
 	var device models.Device
-	if err := r.db.First(&device, id).Error; err != nil {
-		return nil, err
-	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, id).Error; err != nil {
+			return err
+		}
+		if version != 0 && device.Version != version {
+			return ErrVersionMismatch
+		}
 
-	if err := r.db.Model(&device).Updates(updateData).Error; err != nil {
+		before := deviceSnapshot(&device)
+		updateData.Version = device.Version + 1
+		// The status is not editable; it only changes through Transition.
+		if err := tx.Model(&device).Select("Type", "Vendor", "Model", "Serial", "Location", "NetworkNodeID", "Version").Updates(updateData).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, models.AuditActionUpdate, models.AuditEntityDevice, id, before, deviceSnapshot(&device))
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &device, nil
 }
 
-func (r *DeviceRepository) Delete(id uint) error {
-	return r.db.Delete(&models.Device{}, id).Error
+func (r *DeviceRepository) Delete(actor Actor, id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var device models.Device
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		// The foreign key keeps the transitions without the device, so the
+		// audit event records which device they belonged to.
+		var transitions []models.DeviceStatusTransition
+		if err := tx.Where("device_id = ?", id).Order("created_at, id").Find(&transitions).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&device).Error; err != nil {
+			return err
+		}
+		before := deviceSnapshot(&device)
+		before["transitions"] = transitionsSnapshot(transitions)
+		return recordAudit(tx, actor, models.AuditActionDelete, models.AuditEntityDevice, id, before, nil)
+	})
 }
 
-func (r *DeviceRepository) GetAll() ([]models.Device, error) {
-	var devices []models.Device
-	if err := r.db.Find(&devices).Error; err != nil {
+// Transition changes the status of the device under a row lock. decide is
+// called with the locked device and returns the transition to record; the
+// device's status is set to its ToStatus.
+func (r *DeviceRepository) Transition(actor Actor, id uint, decide func(device *models.Device) (*models.DeviceStatusTransition, error)) (*models.Device, *models.DeviceStatusTransition, error) {
+	var device models.Device
+	var transition *models.DeviceStatusTransition
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&device, id).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if transition, err = decide(&device); err != nil {
+			return err
+		}
+
+		transition.DeviceID = &device.ID
+		if err := tx.Create(transition).Error; err != nil {
+			return err
+		}
+
+		before := deviceSnapshot(&device)
+		columns := map[string]interface{}{"status": transition.ToStatus, "version": device.Version + 1}
+		if err := tx.Model(&device).Updates(columns).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, models.AuditActionTransition, models.AuditEntityDevice, id, before, deviceSnapshot(&device))
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &device, transition, nil
+}
+
+func (r *DeviceRepository) GetTransitions(id uint) ([]models.DeviceStatusTransition, error) {
+	var transitions []models.DeviceStatusTransition
+	if err := r.db.Where("device_id = ?", id).Order("created_at, id").Find(&transitions).Error; err != nil {
 		return nil, err
 	}
-	return devices, nil
+	return transitions, nil
+}
+
+// List returns one page of the devices matching filter, the number of
+// matching devices across all pages and the cursor of the next page, which is
+// empty on the last one.
+func (r *DeviceRepository) List(filter DeviceFilter) ([]models.Device, int64, string, error) {
+	sort, ok := deviceSortColumns[filter.SortColumn]
+	if !ok {
+		return nil, 0, "", ErrInvalidFilter.Because(fmt.Sprintf("unknown sort column %q", filter.SortColumn))
+	}
+
+	query := r.db.Model(&models.Device{}).Scopes(filter.scope).Session(&gorm.Session{})
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	direction, compare := "ASC", ">"
+	if filter.SortDesc {
+		direction, compare = "DESC", "<"
+	}
+
+	page := query.Order(sort.expr + " " + direction).Order("id " + direction)
+	if filter.Cursor != "" {
+		value, id, err := decodeDeviceCursor(filter.Cursor, sort)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		page = page.Where(fmt.Sprintf("(%s, id) %s (?, ?)", sort.expr, compare), value, id)
+	}
+
+	var devices []models.Device
+	if err := page.Limit(filter.Limit + 1).Find(&devices).Error; err != nil {
+		return nil, 0, "", err
+	}
+
+	var next string
+	if len(devices) > filter.Limit {
+		devices = devices[:filter.Limit]
+		last := &devices[len(devices)-1]
+		next = encodeDeviceCursor(sort.value(last), last.ID)
+	}
+
+	return devices, total, next, nil
+}
+
+// Stream calls fn for every device matching filter in the order of the
+// listing, reading rows from the database as they are consumed. Limit and
+// Cursor of filter are ignored.
+func (r *DeviceRepository) Stream(filter DeviceFilter, fn func(device *models.Device) error) error {
+	sort, ok := deviceSortColumns[filter.SortColumn]
+	if !ok {
+		return ErrInvalidFilter.Because(fmt.Sprintf("unknown sort column %q", filter.SortColumn))
+	}
+
+	direction := "ASC"
+	if filter.SortDesc {
+		direction = "DESC"
+	}
+
+	rows, err := r.db.Model(&models.Device{}).
+		Scopes(filter.scope).
+		Order(sort.expr + " " + direction).
+		Order("id " + direction).
+		Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var device models.Device
+		if err := r.db.ScanRows(rows, &device); err != nil {
+			return err
+		}
+		if err := fn(&device); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
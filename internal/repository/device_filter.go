@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidFilter = apperror.New(apperror.BadRequest, "invalid device filter")
+
+// DeviceFilter narrows and orders a device listing. Empty string fields do
+// not restrict the result.
+type DeviceFilter struct {
+	Type          string
+	Vendor        string
+	Model         string
+	Status        string
+	Location      string
+	NetworkNodeID *uint
+	Unassigned    bool
+	// ScopeNodeID limits the result to devices within the subtree rooted
+	// at this node.
+	ScopeNodeID *uint
+	// Search matches a substring of the serial number or the model.
+	Search     string
+	SortColumn string
+	SortDesc   bool
+	Limit      int
+	Cursor     string
+}
+
+func (f DeviceFilter) scope(db *gorm.DB) *gorm.DB {
+	for _, match := range [...]struct{ column, value string }{
+		{"type", f.Type},
+		{"vendor", f.Vendor},
+		{"model", f.Model},
+		{"status", f.Status},
+		{"location", f.Location},
+	} {
+		if match.value != "" {
+			db = db.Where(match.column+" = ?", match.value)
+		}
+	}
+
+	switch {
+	case f.Unassigned:
+		db = db.Where("network_node_id IS NULL")
+	case f.NetworkNodeID != nil:
+		db = db.Where("network_node_id = ?", *f.NetworkNodeID)
+	}
+
+	if f.ScopeNodeID != nil {
+		db = db.Where("network_node_id IN ("+subtreeCTE+" SELECT id FROM tree)",
+			sql.Named("id", *f.ScopeNodeID), sql.Named("depth", -1))
+	}
+
+	if f.Search != "" {
+		pattern := "%" + escapeLike(f.Search) + "%"
+		db = db.Where("(serial ILIKE ? OR model ILIKE ?)", pattern, pattern)
+	}
+
+	return db
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+type deviceSortColumn struct {
+	expr  string
+	value func(device *models.Device) interface{}
+	// decode parses the sort value stored in a cursor.
+	decode func(raw json.RawMessage) (interface{}, error)
+}
+
+var deviceSortColumns = map[string]deviceSortColumn{
+	"id":              {"id", func(d *models.Device) interface{} { return d.ID }, decodeCursorValue[uint]},
+	"type":            {"type", func(d *models.Device) interface{} { return d.Type }, decodeCursorValue[string]},
+	"vendor":          {"vendor", func(d *models.Device) interface{} { return d.Vendor }, decodeCursorValue[string]},
+	"model":           {"model", func(d *models.Device) interface{} { return d.Model }, decodeCursorValue[string]},
+	"serial":          {"serial", func(d *models.Device) interface{} { return d.Serial }, decodeCursorValue[string]},
+	"location":        {"location", func(d *models.Device) interface{} { return d.Location }, decodeCursorValue[string]},
+	"status":          {"status", func(d *models.Device) interface{} { return d.Status }, decodeCursorValue[string]},
+	"network_node_id": {"COALESCE(network_node_id, 0)", networkNodeSortValue, decodeCursorValue[uint]},
+	"created_at":      {"created_at", func(d *models.Device) interface{} { return d.CreatedAt }, decodeCursorValue[time.Time]},
+	"updated_at":      {"updated_at", func(d *models.Device) interface{} { return d.UpdatedAt }, decodeCursorValue[time.Time]},
+}
+
+// IsDeviceSortColumn reports whether devices can be ordered by column.
+func IsDeviceSortColumn(column string) bool {
+	_, ok := deviceSortColumns[column]
+	return ok
+}
+
+func networkNodeSortValue(d *models.Device) interface{} {
+	if d.NetworkNodeID == nil {
+		return uint(0)
+	}
+	return *d.NetworkNodeID
+}
+
+func decodeCursorValue[T any](raw json.RawMessage) (interface{}, error) {
+	var value T
+	err := json.Unmarshal(raw, &value)
+	return value, err
+}
+
+// deviceCursor points right after the last device of a page in the order
+// of the listing: the sort value of that device and its ID as a tiebreaker.
+type deviceCursor struct {
+	Value json.RawMessage `json:"v"`
+	ID    uint            `json:"id"`
+}
+
+func encodeDeviceCursor(value interface{}, id uint) string {
+	raw, _ := json.Marshal(value)
+	data, _ := json.Marshal(deviceCursor{Value: raw, ID: id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeDeviceCursor(cursor string, sort deviceSortColumn) (interface{}, uint, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, ErrInvalidFilter.Because("malformed cursor")
+	}
+
+	var c deviceCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, 0, ErrInvalidFilter.Because("malformed cursor")
+	}
+
+	value, err := sort.decode(c.Value)
+	if err != nil {
+		return nil, 0, ErrInvalidFilter.Because("cursor does not match sort column")
+	}
+	return value, c.ID, nil
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"equipment-management/internal/apperror"
+	"errors"
+	"regexp"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// Postgres error codes of the violations translateError knows about.
+const (
+	pgNotNullViolation     = "23502"
+	pgForeignKeyViolation  = "23503"
+	pgUniqueViolation      = "23505"
+	pgCheckViolation       = "23514"
+	pgSerializationFailure = "40001"
+	pgDeadlockDetected     = "40P01"
+)
+
+// errorTranslation is a gorm plugin that runs translateError on the error
+// of every statement, so that callers get apperror errors instead of
+// driver-specific ones.
+type errorTranslation struct{}
+
+func (errorTranslation) Name() string {
+	return "apperror:translation"
+}
+
+func (errorTranslation) Initialize(db *gorm.DB) error {
+	translate := func(tx *gorm.DB) {
+		if tx.Error != nil {
+			tx.Error = translateError(tx.Error, tx.Statement.Table)
+		}
+	}
+
+	callbacks := db.Callback()
+	for _, register := range []func(name string, fn func(*gorm.DB)) error{
+		callbacks.Create().After("*").Register,
+		callbacks.Query().After("*").Register,
+		callbacks.Update().After("*").Register,
+		callbacks.Delete().After("*").Register,
+		callbacks.Row().After("*").Register,
+		callbacks.Raw().After("*").Register,
+	} {
+		if err := register("apperror:translate", translate); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyPattern extracts the columns and the referencing table from the
+// detail of a unique or foreign key violation, such as
+// `Key (serial)=(SN-1) already exists.` or
+// `Key (id)=(3) is still referenced from table "devices".`
+var keyPattern = regexp.MustCompile(`^Key \(([^)]*)\)=\(.*\) (?:already exists|is not present in table "[^"]*"|is still referenced from table "([^"]*)")`)
+
+// notFoundMessages name the records of the tables clients look up by ID.
+var notFoundMessages = map[string]string{
+	"api_keys":      "API key not found",
+	"devices":       "device not found",
+	"network_nodes": "network node not found",
+	"roles":         "role not found",
+	"users":         "user not found",
+}
+
+// translateError turns missing records and constraint violations into
+// apperror errors that keep err as their cause. Other errors are returned
+// unchanged. table is the table of the statement that failed, if known.
+func translateError(err error, table string) error {
+	var appErr *apperror.Error
+	if errors.As(err, &appErr) {
+		return err
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		message, ok := notFoundMessages[table]
+		if !ok {
+			message = "not found"
+		}
+		return apperror.Wrap(apperror.NotFound, message, err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+
+	var column, referencedFrom string
+	if match := keyPattern.FindStringSubmatch(pgErr.Detail); match != nil {
+		column, referencedFrom = match[1], match[2]
+	}
+
+	switch pgErr.Code {
+	case pgUniqueViolation:
+		translated := apperror.Wrap(apperror.Conflict, "a record with this value already exists", err)
+		if column != "" {
+			translated.Message = column + " is already in use"
+			translated.Fields = []apperror.FieldError{{Field: column, Message: "is already in use"}}
+		}
+		return translated
+	case pgForeignKeyViolation:
+		if referencedFrom != "" {
+			return apperror.Wrap(apperror.Conflict, "the record is still referenced from "+referencedFrom, err)
+		}
+		translated := apperror.Wrap(apperror.Validation, "a referenced record does not exist", err)
+		if column != "" {
+			translated.Fields = []apperror.FieldError{{Field: column, Message: "refers to a record that does not exist"}}
+		}
+		return translated
+	case pgNotNullViolation:
+		translated := apperror.Wrap(apperror.Validation, "a required value is missing", err)
+		if pgErr.ColumnName != "" {
+			translated.Fields = []apperror.FieldError{{Field: pgErr.ColumnName, Message: "is required"}}
+		}
+		return translated
+	case pgCheckViolation:
+		return apperror.Wrap(apperror.Validation, "a value is out of the allowed range", err)
+	case pgSerializationFailure, pgDeadlockDetected:
+		return apperror.Wrap(apperror.Conflict, "the data was changed concurrently, try again", err)
+	default:
+		return err
+	}
+}
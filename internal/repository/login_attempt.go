@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"database/sql"
+	"equipment-management/internal/models"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type LoginAttemptRepository struct {
+	db *gorm.DB
+}
+
+func NewLoginAttemptRepository(db *gorm.DB) *LoginAttemptRepository {
+	return &LoginAttemptRepository{db: db}
+}
+
+func (r *LoginAttemptRepository) Record(attempt *models.LoginAttempt) error {
+	return r.db.Create(attempt).Error
+}
+
+// LoginAttemptFilter narrows a listing of login attempts. Zero fields do not
+// restrict it.
+type LoginAttemptFilter struct {
+	Login   string
+	UserID  uint
+	IP      string
+	Success *bool
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Before  uint
+}
+
+// List returns login attempts matching filter, newest first, with IDs below
+// filter.Before when it is set.
+func (r *LoginAttemptRepository) List(filter LoginAttemptFilter) ([]models.LoginAttempt, error) {
+	query := r.db.Model(&models.LoginAttempt{})
+
+	if filter.Login != "" {
+		query = query.Where("login = ?", filter.Login)
+	}
+	if filter.UserID != 0 {
+		query = query.Where("user_id = ?", filter.UserID)
+	}
+	if filter.IP != "" {
+		query = query.Where("ip = ?", filter.IP)
+	}
+	if filter.Success != nil {
+		query = query.Where("success = ?", *filter.Success)
+	}
+	if !filter.Since.IsZero() {
+		query = query.Where("created_at >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		query = query.Where("created_at < ?", filter.Until)
+	}
+	if filter.Before != 0 {
+		query = query.Where("id < ?", filter.Before)
+	}
+
+	var attempts []models.LoginAttempt
+	if err := query.Order("id DESC").Limit(filter.Limit).Find(&attempts).Error; err != nil {
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// ReserveAttempt counts an attempt under each of keys as failed before its
+// outcome is known and returns the updated counters. The counters are
+// locked while check decides from their previous state whether the attempt
+// may be made at all, so that parallel attempts are counted one at a time
+// and cannot pass the limits together; when check fails nothing is counted.
+// Failures that happened before since are forgotten.
+func (r *LoginAttemptRepository) ReserveAttempt(keys []string, now, since time.Time, check func(throttles []models.LoginThrottle) error) ([]models.LoginThrottle, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+
+	var counted []models.LoginThrottle
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		// A key without failures gets its row now, so that there is a row
+		// to lock even for the first attempt.
+		for _, key := range sorted {
+			if err := tx.Exec(`
+INSERT INTO login_throttles (key, failures, last_failure_at) VALUES (?, 0, ?)
+ON CONFLICT (key) DO NOTHING`, key, since).Error; err != nil {
+				return err
+			}
+		}
+
+		var throttles []models.LoginThrottle
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key IN ?", sorted).Order("key").Find(&throttles).Error; err != nil {
+			return err
+		}
+		if err := check(throttles); err != nil {
+			return err
+		}
+
+		counted = make([]models.LoginThrottle, len(sorted))
+		for i, key := range sorted {
+			if err := tx.Raw(`
+UPDATE login_throttles SET
+	failures = CASE WHEN last_failure_at < @since THEN 1 ELSE failures + 1 END,
+	last_failure_at = @now,
+	locked_until = CASE WHEN locked_until > @now THEN locked_until END
+WHERE key = @key
+RETURNING *`, sql.Named("key", key), sql.Named("now", now), sql.Named("since", since)).Scan(&counted[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counted, nil
+}
+
+// ReleaseAttempt takes back an attempt that ReserveAttempt counted under
+// keys but that did not fail.
+func (r *LoginAttemptRepository) ReleaseAttempt(keys ...string) error {
+	return r.db.Model(&models.LoginThrottle{}).Where("key IN ? AND failures > 0", keys).
+		UpdateColumn("failures", gorm.Expr("failures - 1")).Error
+}
+
+// Lock refuses logins for key until the given time.
+func (r *LoginAttemptRepository) Lock(key string, until time.Time) error {
+	return r.db.Model(&models.LoginThrottle{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+// ResetThrottles forgets the failures counted for the given keys.
+func (r *LoginAttemptRepository) ResetThrottles(keys ...string) error {
+	return r.db.Where("key IN ?", keys).Delete(&models.LoginThrottle{}).Error
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+	"fmt"
+	"log"
+
+	"gorm.io/gorm"
+)
+
+// migratedModels are the models whose tables Migrate keeps up to date.
+var migratedModels = []interface{}{
+	&models.User{},
+	&models.Device{},
+	&models.NetworkNode{},
+	&models.DeviceStatusTransition{},
+	&models.AuditEvent{},
+	&models.Session{},
+	&models.Role{},
+	&models.RolePermission{},
+	&models.LoginAttempt{},
+	&models.LoginThrottle{},
+	&models.RecoveryCode{},
+	&models.APIKey{},
+	&models.SSOState{},
+}
+
+// Migrate creates and updates the tables of the models, and then their
+// foreign keys with MigrateForeignKeys. The models leave those foreign keys
+// out, so that rows pointing at deleted nodes do not fail the migration.
+func Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(migratedModels...); err != nil {
+		return fmt.Errorf("migrate tables: %w", err)
+	}
+	if err := MigrateForeignKeys(db); err != nil {
+		return fmt.Errorf("migrate foreign keys: %w", err)
+	}
+	return nil
+}
+
+// foreignKey is a foreign key constraint that MigrateForeignKeys maintains.
+// Deleting the referenced row clears the column, which is what deleting a
+// node does to its devices and children anyway, and keeps the status
+// history of a deleted device.
+type foreignKey struct {
+	Name       string
+	Table      string
+	Column     string
+	References string
+}
+
+// foreignKeys are named like the constraints gorm would derive from the
+// relations of the models.
+var foreignKeys = []foreignKey{
+	{Name: "fk_network_nodes_devices", Table: "devices", Column: "network_node_id", References: "network_nodes"},
+	{Name: "fk_network_nodes_children", Table: "network_nodes", Column: "parent_id", References: "network_nodes"},
+	{Name: "fk_devices_status_transitions", Table: "device_status_transitions", Column: "device_id", References: "devices"},
+}
+
+// MigrateForeignKeys creates the foreign keys of foreignKeys and
+// replaces those that exist with another ON DELETE action. New constraints
+// are added NOT VALID and then validated when no row points at a deleted
+// one; otherwise they stay unvalidated, so that such rows do not fail the
+// migration, until ValidateForeignKeys checks them once they are fixed.
+func MigrateForeignKeys(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, fk := range foreignKeys {
+			var existing []struct {
+				Name       string
+				DeleteType string
+				Validated  bool
+			}
+			if err := tx.Raw(`
+SELECT c.conname AS name, c.confdeltype AS delete_type, c.convalidated AS validated
+FROM pg_constraint c
+JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+WHERE c.contype = 'f' AND c.conrelid = ?::regclass AND cardinality(c.conkey) = 1 AND a.attname = ?`,
+				fk.Table, fk.Column).Scan(&existing).Error; err != nil {
+				return err
+			}
+
+			present, validated := false, false
+			for _, constraint := range existing {
+				// 'n' is ON DELETE SET NULL.
+				if constraint.Name == fk.Name && constraint.DeleteType == "n" {
+					present, validated = true, constraint.Validated
+					continue
+				}
+				if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q DROP CONSTRAINT %q`, fk.Table, constraint.Name)).Error; err != nil {
+					return err
+				}
+			}
+
+			if !present {
+				if err := tx.Exec(fmt.Sprintf(`ALTER TABLE %q ADD CONSTRAINT %q FOREIGN KEY (%q) REFERENCES %q (id) ON DELETE SET NULL NOT VALID`,
+					fk.Table, fk.Name, fk.Column, fk.References)).Error; err != nil {
+					return err
+				}
+				log.Printf("Foreign key %s added", fk.Name)
+			}
+			if !validated {
+				// A failed validation is rolled back to a savepoint and
+				// leaves the rest of the migration alone.
+				if err := tx.Transaction(func(tx *gorm.DB) error {
+					return tx.Exec(fmt.Sprintf(`ALTER TABLE %q VALIDATE CONSTRAINT %q`, fk.Table, fk.Name)).Error
+				}); err != nil {
+					log.Printf("Foreign key %s is not validated; run the orphans command to fix %s.%s", fk.Name, fk.Table, fk.Column)
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// ValidateForeignKeys makes Postgres check the rows that existed before
+// MigrateForeignKeys added the constraints. It fails while any of them
+// still point at a missing row.
+func ValidateForeignKeys(db *gorm.DB) error {
+	for _, fk := range foreignKeys {
+		if err := db.Exec(fmt.Sprintf(`ALTER TABLE %q VALIDATE CONSTRAINT %q`, fk.Table, fk.Name)).Error; err != nil {
+			return fmt.Errorf("validate %s: %w", fk.Name, err)
+		}
+	}
+	return nil
+}
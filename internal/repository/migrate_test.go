@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+func TestModelsLeaveMaintainedForeignKeysToMigrateForeignKeys(t *testing.T) {
+	// AutoMigrate adds the constraints of the relations validated, which
+	// fails on a database with rows pointing at deleted nodes.
+	cache := &sync.Map{}
+	for _, model := range migratedModels {
+		parsed, err := schema.Parse(model, cache, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rel := range parsed.Relationships.Relations {
+			constraint := rel.ParseConstraint()
+			if constraint == nil {
+				continue
+			}
+			for _, fk := range foreignKeys {
+				for _, field := range constraint.ForeignKeys {
+					if constraint.Schema.Table == fk.Table && field.DBName == fk.Column {
+						t.Errorf("relation %s.%s makes AutoMigrate add the foreign key of %s.%s", parsed.Name, rel.Name, fk.Table, fk.Column)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMigrateForeignKeysAcceptsOrphans(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The database predates the foreign keys, and its devices point at a
+	// deleted node.
+	mock.ExpectBegin()
+	for _, fk := range foreignKeys {
+		mock.ExpectQuery(`FROM pg_constraint`).WithArgs(fk.Table, fk.Column).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "delete_type", "validated"}))
+		mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "`+fk.Table+`" ADD CONSTRAINT "`+fk.Name+`"`) + `.* NOT VALID$`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`^SAVEPOINT `).WillReturnResult(sqlmock.NewResult(0, 0))
+		validate := mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE "` + fk.Table + `" VALIDATE CONSTRAINT "` + fk.Name + `"`))
+		if fk.Table == "devices" {
+			validate.WillReturnError(errors.New(`insert or update on table "devices" violates foreign key constraint`))
+			mock.ExpectExec(`^ROLLBACK TO SAVEPOINT `).WillReturnResult(sqlmock.NewResult(0, 0))
+		} else {
+			validate.WillReturnResult(sqlmock.NewResult(0, 0))
+		}
+	}
+	mock.ExpectCommit()
+
+	if err := MigrateForeignKeys(db); err != nil {
+		t.Fatalf("orphans failed the migration: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateForeignKeysKeepsValidatedConstraints(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	for _, fk := range foreignKeys {
+		mock.ExpectQuery(`FROM pg_constraint`).WithArgs(fk.Table, fk.Column).
+			WillReturnRows(sqlmock.NewRows([]string{"name", "delete_type", "validated"}).AddRow(fk.Name, "n", true))
+	}
+	mock.ExpectCommit()
+
+	if err := MigrateForeignKeys(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,10 +1,28 @@
 package repository
 
 import (
+	"database/sql"
+	"equipment-management/internal/apperror"
 	"equipment-management/internal/models"
 	"gorm.io/gorm"
 )
 
+var (
+	ErrParentNotFound = apperror.Invalid("parent node does not exist",
+		apperror.FieldError{Field: "parent_id", Message: "does not exist"})
+	ErrParentCycle = apperror.New(apperror.Conflict, "node cannot be placed under itself or its descendant")
+	// ErrNodeNotFound is returned for a node that does not exist, or that
+	// the actor is not to know about.
+	ErrNodeNotFound = apperror.Wrap(apperror.NotFound, "network node not found", gorm.ErrRecordNotFound)
+	// ErrVersionMismatch is returned when an object was changed since the
+	// version the update is based on.
+	ErrVersionMismatch = apperror.New(apperror.PreconditionFailed, "the object was changed by someone else, reload it and try again")
+)
+
+// treeLockKey identifies the transaction-level advisory lock taken by every
+// change of the node hierarchy, so concurrent moves are checked one by one.
+const treeLockKey = 72430001
+
 type NetworkNodeRepository struct {
 	db *gorm.DB
 }
@@ -13,85 +31,229 @@ func NewNetworkNodeRepository(db *gorm.DB) *NetworkNodeRepository {
 	return &NetworkNodeRepository{db: db}
 }
 
-func (r *NetworkNodeRepository) Create(node *models.NetworkNode) error {
-	return r.db.Create(node).Error
+func (r *NetworkNodeRepository) Create(actor Actor, node *models.NetworkNode) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := checkParent(tx, 0, node.ParentID); err != nil {
+			return err
+		}
+		var err error
+		if node.Position, err = nextPosition(tx, node.ParentID); err != nil {
+			return err
+		}
+		if err := tx.Create(node).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, models.AuditActionCreate, models.AuditEntityNetworkNode, node.ID, nil, networkNodeSnapshot(node))
+	})
 }
 
 func (r *NetworkNodeRepository) GetByID(id uint) (*models.NetworkNode, error) {
 	var node models.NetworkNode
-	if err := r.db.Preload("Devices").Preload("Children").First(&node, id).Error; err != nil {
+	err := r.db.Preload("Devices", orderDevices).Preload("Children", orderSiblings).First(&node, id).Error
+	if err != nil {
 		return nil, err
 	}
 	return &node, nil
 }
 
-func (r *NetworkNodeRepository) Update(id uint, updateData *models.NetworkNode) (*models.NetworkNode, error) {
+// Update replaces the editable fields of the node with those of updateData,
+// zero values included, so a nil ParentID makes it a root. The node has to
+// be still at version; a version of 0 skips the check.
+func (r *NetworkNodeRepository) Update(actor Actor, id, version uint, updateData *models.NetworkNode) (*models.NetworkNode, error) {
 	var node models.NetworkNode
-	if err := r.db.First(&node, id).Error; err != nil {
-		return nil, err
-	}
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := tx.First(&node, id).Error; err != nil {
+			return err
+		}
+		if version != 0 && node.Version != version {
+			return ErrVersionMismatch
+		}
+		if err := checkParent(tx, id, updateData.ParentID); err != nil {
+			return err
+		}
 
-	if err := r.db.Model(&node).Updates(updateData).Error; err != nil {
+		columns := []string{"Name", "Description", "ParentID", "Version"}
+		if optionalID(updateData.ParentID) != optionalID(node.ParentID) {
+			var err error
+			if updateData.Position, err = nextPosition(tx, updateData.ParentID); err != nil {
+				return err
+			}
+			columns = append(columns, "Position")
+		}
+
+		before := networkNodeSnapshot(&node)
+		updateData.Version = node.Version + 1
+		if err := tx.Model(&node).Select(columns).Updates(updateData).Error; err != nil {
+			return err
+		}
+		return recordAudit(tx, actor, models.AuditActionUpdate, models.AuditEntityNetworkNode, id, before, networkNodeSnapshot(&node))
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return &node, nil
 }
 
-func (r *NetworkNodeRepository) Delete(id uint) error {
-	return r.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&models.Device{}).Where("network_node_id = ?", id).Update("network_node_id", nil).Error; err != nil {
-			return err
-		}
-		if err := tx.Model(&models.NetworkNode{}).Where("parent_id = ?", id).Update("parent_id", nil).Error; err != nil {
-			return err
-		}
-		return tx.Delete(&models.NetworkNode{}, id).Error
-	})
+// Exists reports whether the node with the given id exists.
+func (r *NetworkNodeRepository) Exists(id uint) (bool, error) {
+	var count int64
+	if err := r.db.Model(&models.NetworkNode{}).Where("id = ?", id).Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
 
 func (r *NetworkNodeRepository) GetAll() ([]models.NetworkNode, error) {
 	var nodes []models.NetworkNode
-	if err := r.db.Find(&nodes).Error; err != nil {
+	if err := orderSiblings(r.db).Find(&nodes).Error; err != nil {
 		return nil, err
 	}
 	return nodes, nil
 }
 
-func (r *NetworkNodeRepository) GetFullTree() ([]models.NetworkNode, error) {
-	var nodes []models.NetworkNode
-	err := r.db.
-		Preload("Devices").
-		Preload("Children").
-		Where("parent_id IS NULL").
-		Find(&nodes).Error
+// fullTreeCTE selects every node reachable from a root, so the whole
+// forest is read in one round-trip regardless of its depth or size.
+const fullTreeCTE = `
+WITH RECURSIVE tree AS (
+	SELECT id FROM network_nodes WHERE parent_id IS NULL
+	UNION ALL
+	SELECT n.id FROM network_nodes n JOIN tree t ON n.parent_id = t.id
+)`
+
+// subtreeCTE selects the node with the given id and its descendants down to
+// the given depth; a negative depth selects the whole branch. Like GetPath it
+// tracks the nodes seen on the way down, so that a cycle in corrupt data
+// ends the recursion.
+const subtreeCTE = `
+WITH RECURSIVE tree AS (
+	SELECT id, 0 AS depth, ARRAY[id] AS seen FROM network_nodes WHERE id = @id
+	UNION ALL
+	SELECT n.id, t.depth + 1, t.seen || n.id FROM network_nodes n JOIN tree t ON n.parent_id = t.id
+	WHERE (@depth < 0 OR t.depth < @depth) AND NOT n.id = ANY(t.seen)
+)`
+
+// GetFullTree returns the nodes of the forest together with the devices
+// attached to them as flat lists. The tree itself is assembled by the caller.
+func (r *NetworkNodeRepository) GetFullTree() ([]models.NetworkNode, []models.Device, error) {
+	return r.loadTree(fullTreeCTE)
+}
 
+// GetSubtree is GetFullTree for a single branch rooted at id.
+func (r *NetworkNodeRepository) GetSubtree(id uint, depth int) ([]models.NetworkNode, []models.Device, error) {
+	nodes, devices, err := r.loadTree(subtreeCTE, sql.Named("id", id), sql.Named("depth", depth))
 	if err != nil {
+		return nil, nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, nil, ErrNodeNotFound
+	}
+	return nodes, devices, nil
+}
+
+// InSubtree reports whether the node with the given id is rootID itself or
+// one of its descendants.
+func (r *NetworkNodeRepository) InSubtree(rootID, id uint) (bool, error) {
+	var inside bool
+	err := r.db.Raw(`
+WITH RECURSIVE ancestors AS (
+	SELECT id, parent_id, ARRAY[id] AS seen FROM network_nodes WHERE id = @id
+	UNION ALL
+	SELECT n.id, n.parent_id, a.seen || n.id
+	FROM network_nodes n JOIN ancestors a ON n.id = a.parent_id
+	WHERE NOT n.id = ANY(a.seen)
+)
+SELECT EXISTS (SELECT 1 FROM ancestors WHERE id = @root)`,
+		sql.Named("id", id), sql.Named("root", rootID)).Row().Scan(&inside)
+	return inside, err
+}
+
+// GetPath returns the chain of nodes from the root down to and including
+// the node with the given id.
+func (r *NetworkNodeRepository) GetPath(id uint) ([]models.NetworkNode, error) {
+	var nodes []models.NetworkNode
+	if err := r.db.Raw(`
+WITH RECURSIVE ancestors AS (
+	SELECT id, parent_id, 0 AS depth, ARRAY[id] AS seen FROM network_nodes WHERE id = ?
+	UNION ALL
+	SELECT n.id, n.parent_id, a.depth + 1, a.seen || n.id
+	FROM network_nodes n JOIN ancestors a ON n.id = a.parent_id
+	WHERE NOT n.id = ANY(a.seen)
+)
+SELECT network_nodes.* FROM network_nodes JOIN ancestors ON ancestors.id = network_nodes.id
+ORDER BY ancestors.depth DESC`, id).Scan(&nodes).Error; err != nil {
 		return nil, err
 	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// loadTree reads the nodes selected by the recursive CTE cte, which must
+// define a "tree" relation with an id column, and the devices attached to them.
+// Siblings come in their order, devices by type and model.
+func (r *NetworkNodeRepository) loadTree(cte string, args ...interface{}) ([]models.NetworkNode, []models.Device, error) {
+	var nodes []models.NetworkNode
+	if err := r.db.Raw(cte+`
+SELECT network_nodes.* FROM network_nodes JOIN tree ON tree.id = network_nodes.id
+ORDER BY network_nodes.position, network_nodes.id`, args...).Scan(&nodes).Error; err != nil {
+		return nil, nil, err
+	}
 
-	for i := range nodes {
-		if err := r.loadChildrenRecursive(&nodes[i]); err != nil {
-			return nil, err
-		}
+	var devices []models.Device
+	if err := r.db.Raw(cte+`
+SELECT devices.* FROM devices JOIN tree ON tree.id = devices.network_node_id
+ORDER BY devices.type, devices.model, devices.id`, args...).Scan(&devices).Error; err != nil {
+		return nil, nil, err
 	}
 
-	return nodes, nil
+	return nodes, devices, nil
 }
 
-func (r *NetworkNodeRepository) loadChildrenRecursive(node *models.NetworkNode) error {
-	if err := r.db.
-		Preload("Devices").
-		Preload("Children").
-		Where("parent_id = ?", node.ID).
-		Find(&node.Children).Error; err != nil {
+func lockTree(tx *gorm.DB) error {
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", treeLockKey).Error
+}
+
+// checkParent verifies that parentID exists and that attaching node id to it
+// keeps the hierarchy acyclic. An id of 0 denotes a node not yet created.
+func checkParent(tx *gorm.DB, id uint, parentID *uint) error {
+	if parentID == nil {
+		return nil
+	}
+	if id != 0 && *parentID == id {
+		return ErrParentCycle
+	}
+
+	var count int64
+	if err := tx.Model(&models.NetworkNode{}).Where("id = ?", *parentID).Count(&count).Error; err != nil {
 		return err
 	}
+	if count == 0 {
+		return ErrParentNotFound
+	}
+	if id == 0 {
+		return nil
+	}
 
-	for i := range node.Children {
-		if err := r.loadChildrenRecursive(&node.Children[i]); err != nil {
-			return err
-		}
+	var cycles int64
+	if err := tx.Raw(`
+WITH RECURSIVE ancestors AS (
+	SELECT id, parent_id FROM network_nodes WHERE id = ?
+	UNION
+	SELECT n.id, n.parent_id FROM network_nodes n JOIN ancestors a ON n.id = a.parent_id
+)
+SELECT COUNT(*) FROM ancestors WHERE id = ?`, *parentID, id).Scan(&cycles).Error; err != nil {
+		return err
+	}
+	if cycles > 0 {
+		return ErrParentCycle
 	}
 
 	return nil
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"database/sql"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+// DeleteMode decides what happens to the children and devices of a deleted
+// network node.
+type DeleteMode string
+
+const (
+	// DeleteOrphan makes the children roots and unassigns the devices.
+	DeleteOrphan DeleteMode = "orphan"
+	// DeleteReparent moves the children and devices to the parent of the
+	// node, or orphans them when the node is a root.
+	DeleteReparent DeleteMode = "reparent"
+	// DeleteCascade deletes the whole branch and unassigns its devices,
+	// decommissioning those that can be.
+	DeleteCascade DeleteMode = "cascade"
+	// DeleteRefuse deletes the node only when it has neither children nor
+	// devices.
+	DeleteRefuse DeleteMode = "refuse"
+)
+
+// ErrNodeNotEmpty is returned by Delete in DeleteRefuse mode.
+var ErrNodeNotEmpty = apperror.New(apperror.Conflict, "the network node still has child nodes or devices")
+
+// DeletePlan describes what deleting a node in a given mode changes.
+type DeletePlan struct {
+	Mode DeleteMode
+	Node models.NetworkNode
+	// Deleted are the nodes that are deleted: the node itself and, in
+	// cascade mode, its descendants.
+	Deleted []models.NetworkNode
+	// Moved are the nodes whose parent becomes Target.
+	Moved []models.NetworkNode
+	// Devices are the devices whose node becomes Target.
+	Devices []models.Device
+	// Target is the new parent of Moved and the new node of Devices; nil
+	// makes them roots and unassigned.
+	Target *uint
+	// Refused reports that the node cannot be deleted in refuse mode;
+	// Moved and Devices then list what is in the way.
+	Refused bool
+}
+
+// PlanDelete returns what Delete would change, without changing anything.
+func (r *NetworkNodeRepository) PlanDelete(id uint, mode DeleteMode) (*DeletePlan, error) {
+	return planDelete(r.db, id, mode)
+}
+
+// Delete deletes the node according to mode and returns what it changed.
+// In cascade mode decommission is called with the node and every device of
+// the branch and returns the status transition to record for the device, or
+// nil to leave its status alone. Deleting a node that does not exist is not an error.
+func (r *NetworkNodeRepository) Delete(actor Actor, id uint, mode DeleteMode, decommission func(node *models.NetworkNode, device *models.Device) *models.DeviceStatusTransition) (*DeletePlan, error) {
+	var plan *DeletePlan
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+
+		var err error
+		if plan, err = planDelete(tx, id, mode); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				plan = &DeletePlan{Mode: mode}
+				return nil
+			}
+			return err
+		}
+		if plan.Refused {
+			return ErrNodeNotEmpty
+		}
+
+		for i := range plan.Devices {
+			device := plan.Devices[i]
+			before := deviceSnapshot(&device)
+			action := models.AuditActionUpdate
+			columns := map[string]interface{}{"network_node_id": plan.Target, "version": device.Version + 1}
+			if mode == DeleteCascade && decommission != nil {
+				if transition := decommission(&plan.Node, &device); transition != nil {
+					transition.DeviceID = &device.ID
+					if err := tx.Create(transition).Error; err != nil {
+						return err
+					}
+					columns["status"] = transition.ToStatus
+					action = models.AuditActionTransition
+				}
+			}
+			if err := tx.Model(&device).Updates(columns).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, action, models.AuditEntityDevice, device.ID, before, deviceSnapshot(&device)); err != nil {
+				return err
+			}
+		}
+
+		position, err := nextPosition(tx, plan.Target)
+		if err != nil {
+			return err
+		}
+		for i := range plan.Moved {
+			node := plan.Moved[i]
+			before := networkNodeSnapshot(&node)
+			// The children keep their order after the new siblings.
+			columns := map[string]interface{}{"parent_id": plan.Target, "position": position + i, "version": node.Version + 1}
+			if err := tx.Model(&node).Updates(columns).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, models.AuditActionUpdate, models.AuditEntityNetworkNode, node.ID, before, networkNodeSnapshot(&node)); err != nil {
+				return err
+			}
+		}
+
+		// Descendants go first, so that no row is left pointing at a
+		// deleted parent in between.
+		for i := len(plan.Deleted) - 1; i >= 0; i-- {
+			node := plan.Deleted[i]
+			if err := tx.Delete(&node).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, models.AuditActionDelete, models.AuditEntityNetworkNode, node.ID, networkNodeSnapshot(&node), nil); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func planDelete(db *gorm.DB, id uint, mode DeleteMode) (*DeletePlan, error) {
+	plan := &DeletePlan{Mode: mode}
+	if err := db.First(&plan.Node, id).Error; err != nil {
+		return nil, err
+	}
+
+	if mode == DeleteCascade {
+		// Ordered parents before children, as subtreeCTE walks down.
+		if err := db.Raw(subtreeCTE+`
+SELECT network_nodes.* FROM network_nodes JOIN tree ON tree.id = network_nodes.id
+ORDER BY tree.depth, network_nodes.position, network_nodes.id`, sql.Named("id", id), sql.Named("depth", -1)).Scan(&plan.Deleted).Error; err != nil {
+			return nil, err
+		}
+		if err := db.Raw(subtreeCTE+`
+SELECT devices.* FROM devices JOIN tree ON tree.id = devices.network_node_id
+ORDER BY devices.id`, sql.Named("id", id), sql.Named("depth", -1)).Scan(&plan.Devices).Error; err != nil {
+			return nil, err
+		}
+		return plan, nil
+	}
+
+	plan.Deleted = []models.NetworkNode{plan.Node}
+	if err := orderSiblings(db.Where("parent_id = ?", id)).Find(&plan.Moved).Error; err != nil {
+		return nil, err
+	}
+	if err := db.Where("network_node_id = ?", id).Order("id").Find(&plan.Devices).Error; err != nil {
+		return nil, err
+	}
+	switch mode {
+	case DeleteReparent:
+		plan.Target = plan.Node.ParentID
+	case DeleteRefuse:
+		plan.Refused = len(plan.Moved) > 0 || len(plan.Devices) > 0
+	}
+	return plan, nil
+}
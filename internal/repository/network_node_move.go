@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"database/sql"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// ErrIncompleteOrder is returned by Reorder when the IDs are not exactly
+// the children of the parent.
+var ErrIncompleteOrder = apperror.Invalid("invalid order",
+	apperror.FieldError{Field: "ids", Message: "must list every child of the parent exactly once"})
+
+// Reorder sets the order of the children of parentID, or of the roots when
+// it is nil, to that of ids, which has to list each of them exactly once.
+// Like in Move, positions are not audited and do not change the versions.
+func (r *NetworkNodeRepository) Reorder(parentID *uint, ids []uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := checkParent(tx, 0, parentID); err != nil {
+			return err
+		}
+
+		var siblings []models.NetworkNode
+		if err := siblingsOf(tx, parentID).Find(&siblings).Error; err != nil {
+			return err
+		}
+		positions := make(map[uint]int, len(siblings))
+		for _, sibling := range siblings {
+			positions[sibling.ID] = sibling.Position
+		}
+		if len(ids) != len(siblings) {
+			return ErrIncompleteOrder
+		}
+		seen := make(map[uint]bool, len(ids))
+		for _, id := range ids {
+			if _, ok := positions[id]; !ok || seen[id] {
+				return ErrIncompleteOrder
+			}
+			seen[id] = true
+		}
+
+		for i, id := range ids {
+			if positions[id] == i {
+				continue
+			}
+			if err := tx.Model(&models.NetworkNode{}).Where("id = ?", id).UpdateColumn("position", i).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Move attaches the node with its whole branch to parentID, or makes it a
+// root when parentID is nil. The node is put at position among its new
+// siblings, or after the last of them when position is nil; the siblings
+// are renumbered accordingly. The move is audited as a single event.
+func (r *NetworkNodeRepository) Move(actor Actor, id uint, parentID *uint, position *int) (*models.NetworkNode, error) {
+	var node models.NetworkNode
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := tx.First(&node, id).Error; err != nil {
+			return err
+		}
+		if err := checkParent(tx, id, parentID); err != nil {
+			return err
+		}
+
+		var siblings []models.NetworkNode
+		if err := orderSiblings(siblingsOf(tx, parentID).Where("id <> ?", id)).Find(&siblings).Error; err != nil {
+			return err
+		}
+		index := len(siblings)
+		if position != nil && *position < index {
+			index = *position
+		}
+		// Positions only order the nodes for display, so shifting the
+		// siblings is neither audited nor a new version of them.
+		for i := range siblings {
+			want := i
+			if i >= index {
+				want = i + 1
+			}
+			if siblings[i].Position == want {
+				continue
+			}
+			if err := tx.Model(&siblings[i]).UpdateColumn("position", want).Error; err != nil {
+				return err
+			}
+		}
+
+		before := networkNodeSnapshot(&node)
+		before["position"] = node.Position
+		columns := map[string]interface{}{"parent_id": parentID, "position": index, "version": node.Version + 1}
+		if err := tx.Model(&node).Updates(columns).Error; err != nil {
+			return err
+		}
+		after := networkNodeSnapshot(&node)
+		after["position"] = node.Position
+		return recordAudit(tx, actor, models.AuditActionMove, models.AuditEntityNetworkNode, id, before, after)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Copy clones the branch rooted at the node under parentID, or as a new
+// root when parentID is nil, after the last of its new siblings. A
+// non-empty name renames the copy of the node itself. With withDevices
+// the devices of the branch are copied as well; since serials are unique,
+// a copied device is an ordered one whose serial is the original serial
+// suffixed with the ID of its new node. The copy is audited as an event on
+// the new node, and each copied device like a created one, with its
+// initial status recorded as a transition from the status of the original.
+func (r *NetworkNodeRepository) Copy(actor Actor, id uint, parentID *uint, name string, withDevices bool) (*models.NetworkNode, []CopiedDevice, error) {
+	var root models.NetworkNode
+	var copied []CopiedDevice
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := tx.First(&models.NetworkNode{}, id).Error; err != nil {
+			return err
+		}
+		if err := checkParent(tx, 0, parentID); err != nil {
+			return err
+		}
+
+		// The branch is read before anything is created, so copying a node
+		// into its own branch does not copy the copy.
+		var nodes []models.NetworkNode
+		if err := tx.Raw(subtreeCTE+`
+SELECT network_nodes.* FROM network_nodes JOIN tree ON tree.id = network_nodes.id
+ORDER BY tree.depth, network_nodes.position, network_nodes.id`, sql.Named("id", id), sql.Named("depth", -1)).Scan(&nodes).Error; err != nil {
+			return err
+		}
+		var devices []models.Device
+		if withDevices {
+			if err := tx.Raw(subtreeCTE+`
+SELECT devices.* FROM devices JOIN tree ON tree.id = devices.network_node_id
+ORDER BY devices.type, devices.model, devices.id`, sql.Named("id", id), sql.Named("depth", -1)).Scan(&devices).Error; err != nil {
+				return err
+			}
+		}
+
+		position, err := nextPosition(tx, parentID)
+		if err != nil {
+			return err
+		}
+
+		copies := make(map[uint]uint, len(nodes))
+		for _, node := range nodes {
+			clone := models.NetworkNode{
+				Name:        node.Name,
+				Description: node.Description,
+				Position:    node.Position,
+			}
+			if node.ID == id {
+				clone.ParentID = parentID
+				clone.Position = position
+				if name != "" {
+					clone.Name = name
+				}
+			} else {
+				newParentID := copies[*node.ParentID]
+				clone.ParentID = &newParentID
+			}
+			if err := tx.Create(&clone).Error; err != nil {
+				return err
+			}
+			copies[node.ID] = clone.ID
+			if node.ID == id {
+				root = clone
+			}
+		}
+
+		for _, device := range devices {
+			nodeID := copies[*device.NetworkNodeID]
+			clone := models.Device{
+				Type:          device.Type,
+				Vendor:        device.Vendor,
+				Model:         device.Model,
+				Serial:        fmt.Sprintf("%s-%d", device.Serial, nodeID),
+				Location:      device.Location,
+				Status:        models.DeviceStatusOrdered,
+				NetworkNodeID: &nodeID,
+			}
+			if err := tx.Create(&clone).Error; err != nil {
+				return err
+			}
+			if err := tx.Create(&models.DeviceStatusTransition{
+				DeviceID:   &clone.ID,
+				FromStatus: device.Status,
+				ToStatus:   clone.Status,
+				Reason:     fmt.Sprintf("copy of device %d", device.ID),
+			}).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, models.AuditActionCreate, models.AuditEntityDevice, clone.ID, nil, deviceSnapshot(&clone)); err != nil {
+				return err
+			}
+			copied = append(copied, CopiedDevice{SourceID: device.ID, Device: clone})
+		}
+
+		after := networkNodeSnapshot(&root)
+		after["copied_from"] = id
+		after["nodes"] = len(nodes)
+		after["devices"] = len(devices)
+		return recordAudit(tx, actor, models.AuditActionCopy, models.AuditEntityNetworkNode, root.ID, nil, after)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &root, copied, nil
+}
+
+// CopiedDevice is a device created by Copy together with the ID of the
+// device it is a copy of.
+type CopiedDevice struct {
+	SourceID uint
+	Device   models.Device
+}
+
+// siblingsOf selects the children of parentID, or the roots when it is nil.
+func siblingsOf(tx *gorm.DB, parentID *uint) *gorm.DB {
+	query := tx.Model(&models.NetworkNode{})
+	if parentID == nil {
+		return query.Where("parent_id IS NULL")
+	}
+	return query.Where("parent_id = ?", *parentID)
+}
+
+// nextPosition returns the position after the last child of parentID, or
+// after the last root when it is nil.
+func nextPosition(tx *gorm.DB, parentID *uint) (int, error) {
+	var last sql.NullInt64
+	if err := siblingsOf(tx, parentID).Select("MAX(position)").Scan(&last).Error; err != nil {
+		return 0, err
+	}
+	if !last.Valid {
+		return 0, nil
+	}
+	return int(last.Int64) + 1, nil
+}
+
+// orderSiblings sorts nodes in the order of their positions.
+func orderSiblings(db *gorm.DB) *gorm.DB {
+	return db.Order("position, id")
+}
+
+// orderDevices sorts devices the way they are listed under their node.
+func orderDevices(db *gorm.DB) *gorm.DB {
+	return db.Order("type, model, id")
+}
@@ -0,0 +1,93 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Devices and nodes that reference a node which no longer exists. Such rows
+// predate the foreign keys of MigrateForeignKeys.
+const (
+	orphanedDevices = "network_node_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM network_nodes n WHERE n.id = devices.network_node_id)"
+	orphanedNodes   = "parent_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM network_nodes p WHERE p.id = network_nodes.parent_id)"
+)
+
+// FindOrphans returns the devices assigned to a node that does not exist.
+func (r *DeviceRepository) FindOrphans() ([]models.Device, error) {
+	var devices []models.Device
+	if err := r.db.Where(orphanedDevices).Order("id").Find(&devices).Error; err != nil {
+		return nil, err
+	}
+	return devices, nil
+}
+
+// DetachOrphans unassigns the devices assigned to a node that does not
+// exist and returns them as they were before.
+func (r *DeviceRepository) DetachOrphans(actor Actor) ([]models.Device, error) {
+	var orphans []models.Device
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(orphanedDevices).Order("id").Find(&orphans).Error; err != nil {
+			return err
+		}
+
+		for _, orphan := range orphans {
+			device := orphan
+			columns := map[string]interface{}{"network_node_id": nil, "version": device.Version + 1}
+			if err := tx.Model(&device).Updates(columns).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, models.AuditActionUpdate, models.AuditEntityDevice, device.ID, deviceSnapshot(&orphan), deviceSnapshot(&device)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
+
+// FindOrphans returns the nodes whose parent does not exist.
+func (r *NetworkNodeRepository) FindOrphans() ([]models.NetworkNode, error) {
+	var nodes []models.NetworkNode
+	if err := r.db.Where(orphanedNodes).Order("id").Find(&nodes).Error; err != nil {
+		return nil, err
+	}
+	return nodes, nil
+}
+
+// DetachOrphans makes the nodes whose parent does not exist roots and
+// returns them as they were before.
+func (r *NetworkNodeRepository) DetachOrphans(actor Actor) ([]models.NetworkNode, error) {
+	var orphans []models.NetworkNode
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := lockTree(tx); err != nil {
+			return err
+		}
+		if err := tx.Where(orphanedNodes).Order("id").Find(&orphans).Error; err != nil {
+			return err
+		}
+
+		for _, orphan := range orphans {
+			node := orphan
+			columns := map[string]interface{}{"parent_id": nil, "version": node.Version + 1}
+			if err := tx.Model(&node).Updates(columns).Error; err != nil {
+				return err
+			}
+			if err := recordAudit(tx, actor, models.AuditActionUpdate, models.AuditEntityNetworkNode, node.ID, networkNodeSnapshot(&orphan), networkNodeSnapshot(&node)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return orphans, nil
+}
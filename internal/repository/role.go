@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+	"gorm.io/gorm"
+)
+
+type RoleRepository struct {
+	db *gorm.DB
+}
+
+func NewRoleRepository(db *gorm.DB) *RoleRepository {
+	return &RoleRepository{db: db}
+}
+
+func (r *RoleRepository) Create(role *models.Role) error {
+	return r.db.Create(role).Error
+}
+
+func (r *RoleRepository) GetByID(id uint) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.Preload("Permissions").First(&role, id).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleRepository) GetByName(name string) (*models.Role, error) {
+	var role models.Role
+	if err := r.db.Preload("Permissions").Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *RoleRepository) GetAll() ([]models.Role, error) {
+	var roles []models.Role
+	if err := r.db.Preload("Permissions").Order("id").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+// Update saves the name, description and permissions of the role. Users
+// holding the role follow a rename.
+func (r *RoleRepository) Update(role *models.Role, oldName string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(role).Updates(map[string]interface{}{
+			"name":        role.Name,
+			"description": role.Description,
+		}).Error; err != nil {
+			return err
+		}
+		if role.Name != oldName {
+			if err := tx.Model(&models.User{}).Where("role = ?", oldName).Update("role", role.Name).Error; err != nil {
+				return err
+			}
+		}
+		return replacePermissions(tx, role)
+	})
+}
+
+// SetRequireMFA sets whether users of the role must use a second factor.
+func (r *RoleRepository) SetRequireMFA(role *models.Role, required bool) error {
+	return r.db.Model(role).Update("require_mfa", required).Error
+}
+
+// SetPermissions replaces the permissions of the role.
+func (r *RoleRepository) SetPermissions(role *models.Role) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return replacePermissions(tx, role)
+	})
+}
+
+func (r *RoleRepository) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("role_id = ?", id).Delete(&models.RolePermission{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Role{}, id).Error
+	})
+}
+
+// CountUsers returns the number of users holding the named role.
+func (r *RoleRepository) CountUsers(name string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("role = ?", name).Count(&count).Error
+	return count, err
+}
+
+func replacePermissions(tx *gorm.DB, role *models.Role) error {
+	if err := tx.Where("role_id = ?", role.ID).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+	if len(role.Permissions) == 0 {
+		return nil
+	}
+	for i := range role.Permissions {
+		role.Permissions[i].RoleID = role.ID
+	}
+	return tx.Create(&role.Permissions).Error
+}
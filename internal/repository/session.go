@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrStaleRefreshToken = apperror.New(apperror.Unauthorized, "refresh token was already used")
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(session *models.Session) error {
+	return r.db.Create(session).Error
+}
+
+func (r *SessionRepository) GetByID(id uint) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) GetByRefreshHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.Where("refresh_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) GetByPreviousHash(hash string) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.Where("previous_token_hash = ?", hash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// Rotate replaces the refresh token of an active session. It fails with
+// ErrStaleRefreshToken when the session's token has changed in the meantime.
+func (r *SessionRepository) Rotate(session *models.Session, newHash string, expiresAt time.Time) error {
+	result := r.db.Model(&models.Session{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, session.RefreshTokenHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":  newHash,
+			"previous_token_hash": session.RefreshTokenHash,
+			"expires_at":          expiresAt,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrStaleRefreshToken
+	}
+
+	session.PreviousTokenHash = session.RefreshTokenHash
+	session.RefreshTokenHash = newHash
+	session.ExpiresAt = expiresAt
+	return nil
+}
+
+func (r *SessionRepository) Revoke(id uint) error {
+	return r.db.Model(&models.Session{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllForUser ends every session of the user.
+func (r *SessionRepository) RevokeAllForUser(userID uint) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// RevokeOthersForUser ends every session of the user except keepID.
+func (r *SessionRepository) RevokeOthersForUser(userID, keepID uint) error {
+	return r.db.Model(&models.Session{}).
+		Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+		Update("revoked_at", time.Now()).Error
+}
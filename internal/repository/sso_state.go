@@ -0,0 +1,40 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SSOStateRepository struct {
+	db *gorm.DB
+}
+
+func NewSSOStateRepository(db *gorm.DB) *SSOStateRepository {
+	return &SSOStateRepository{db: db}
+}
+
+func (r *SSOStateRepository) Create(state *models.SSOState) error {
+	return r.db.Create(state).Error
+}
+
+// Take removes the state and returns it, so that each state is used only
+// once.
+func (r *SSOStateRepository) Take(state string) (*models.SSOState, error) {
+	var taken models.SSOState
+	result := r.db.Clauses(clause.Returning{}).Where("state = ?", state).Delete(&taken)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &taken, nil
+}
+
+// DeleteExpired removes the states of logins that were never completed.
+func (r *SSOStateRepository) DeleteExpired(now time.Time) error {
+	return r.db.Where("expires_at < ?", now).Delete(&models.SSOState{}).Error
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"equipment-management/internal/models"
+	"gorm.io/gorm"
+)
+
+type UserRepository struct {
+	db *gorm.DB
+}
+
+func NewUserRepository(db *gorm.DB) *UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *UserRepository) GetByID(id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByLogin(login string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("login = ?", login).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetByOIDCSubject returns the single sign-on user of the account subject
+// at issuer.
+func (r *UserRepository) GetByOIDCSubject(issuer, subject string) (*models.User, error) {
+	var user models.User
+	if err := r.db.Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetAll() ([]models.User, error) {
+	var users []models.User
+	if err := r.db.Order("id").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Update writes the given columns of the user.
+func (r *UserRepository) Update(user *models.User, columns map[string]interface{}) error {
+	return r.db.Model(user).Updates(columns).Error
+}
+
+// AdvanceTOTPStep records step as the last accepted TOTP time step of the
+// user. It reports false when a code of that or a later step was accepted
+// already.
+func (r *UserRepository) AdvanceTOTPStep(id uint, step int64) (bool, error) {
+	result := r.db.Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *UserRepository) Delete(id uint) error {
+	return r.db.Delete(&models.User{}, id).Error
+}
+
+// CountActiveAdmins returns the number of enabled users without a scope
+// who hold one of roles, other than the user with the given id.
+func (r *UserRepository) CountActiveAdmins(exceptID uint, roles []string) (int64, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).
+		Where("role IN ? AND NOT disabled AND scope_node_id IS NULL AND id <> ?", roles, exceptID).
+		Count(&count).Error
+	return count, err
+}
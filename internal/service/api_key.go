@@ -0,0 +1,138 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/auth"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidAPIKey     = apperror.New(apperror.Unauthorized, "invalid API key")
+	ErrNotServiceAccount = apperror.New(apperror.Conflict, "API keys can only be issued to service accounts")
+	ErrKeyExpiry         = apperror.Invalid("expiry must be in the future",
+		apperror.FieldError{Field: "expires_at", Message: "must be in the future"})
+)
+
+// apiKeyPrefixLength is the number of leading characters of a key that are
+// kept to tell keys apart.
+const apiKeyPrefixLength = 12
+
+// lastUsedPrecision limits how often the last use of a key is written.
+const lastUsedPrecision = time.Minute
+
+// APIKeyService issues the keys that service accounts authenticate with.
+type APIKeyService struct {
+	keys  *repository.APIKeyRepository
+	users *repository.UserRepository
+}
+
+func NewAPIKeyService(keys *repository.APIKeyRepository, users *repository.UserRepository) *APIKeyService {
+	return &APIKeyService{keys: keys, users: users}
+}
+
+// CreateKey issues a new key for the service account. The key itself is
+// returned only here; just its hash is stored.
+func (s *APIKeyService) CreateKey(userID uint, req *dto.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !user.ServiceAccount {
+		return nil, "", ErrNotServiceAccount
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrKeyExpiry
+	}
+
+	secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := models.APIKey{
+		UserID:    user.ID,
+		Name:      strings.TrimSpace(req.Name),
+		Prefix:    secret[:apiKeyPrefixLength],
+		KeyHash:   auth.HashToken(secret),
+		ExpiresAt: req.ExpiresAt,
+	}
+	if err := s.keys.Create(&key); err != nil {
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+func (s *APIKeyService) ListKeys(userID uint) ([]models.APIKey, error) {
+	if _, err := s.users.GetByID(userID); err != nil {
+		return nil, err
+	}
+	return s.keys.ListForUser(userID)
+}
+
+// RevokeKey disables the key for good.
+func (s *APIKeyService) RevokeKey(userID, id uint) error {
+	key, err := s.keys.GetForUser(userID, id)
+	if err != nil {
+		return err
+	}
+	return s.keys.Revoke(key)
+}
+
+// Authenticate checks an API key and returns the service account it belongs
+// to. Requests made with a key are not part of a session.
+func (s *APIKeyService) Authenticate(secret string) (*Principal, error) {
+	key, err := s.keys.GetByHash(auth.HashToken(secret))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.users.GetByID(key.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled || !user.ServiceAccount {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if err := s.keys.TouchLastUsed(key.ID, now, now.Add(-lastUsedPrecision)); err != nil {
+		return nil, err
+	}
+	return &Principal{UserID: user.ID, Role: user.Role, ScopeNodeID: user.ScopeNodeID}, nil
+}
+
+func (s *APIKeyService) ToAPIKeyResponse(key *models.APIKey) dto.APIKeyResponse {
+	return dto.APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     key.Prefix,
+		ExpiresAt:  formatOptionalTime(key.ExpiresAt),
+		LastUsedAt: formatOptionalTime(key.LastUsedAt),
+		RevokedAt:  formatOptionalTime(key.RevokedAt),
+		CreatedAt:  key.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/json"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"time"
+)
+
+const DefaultAuditPageSize = 100
+
+type AuditService struct {
+	repo    *repository.AuditRepository
+	devices *DeviceService
+}
+
+func NewAuditService(repo *repository.AuditRepository, devices *DeviceService) *AuditService {
+	return &AuditService{repo: repo, devices: devices}
+}
+
+// ListEvents returns one page of audit events, newest first, and the cursor
+// of the next page, which is 0 on the last one.
+func (s *AuditService) ListEvents(query *dto.AuditQuery) ([]repository.AuditRecord, uint, error) {
+	filter := repository.AuditFilter{
+		EntityType: query.EntityType,
+		EntityID:   query.EntityID,
+		ActorID:    query.ActorID,
+		Action:     query.Action,
+		Field:      query.Field,
+		Since:      query.Since,
+		Until:      query.Until,
+		Limit:      query.Limit,
+		Before:     query.Cursor,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+
+	records, err := s.repo.List(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint
+	if len(records) == filter.Limit {
+		next = records[len(records)-1].ID
+	}
+	return records, next, nil
+}
+
+// GetDeviceHistory returns the audit events of a single device. The history
+// of a device outside of the actor's scope is reported as not found.
+func (s *AuditService) GetDeviceHistory(actor repository.Actor, id uint, query *dto.AuditQuery) ([]repository.AuditRecord, uint, error) {
+	if err := s.devices.CheckDevice(actor, id); err != nil {
+		return nil, 0, err
+	}
+
+	query.EntityType = models.AuditEntityDevice
+	query.EntityID = id
+	return s.ListEvents(query)
+}
+
+func (s *AuditService) ToAuditEventResponse(record *repository.AuditRecord) dto.AuditEventResponse {
+	return dto.AuditEventResponse{
+		ID:         record.ID,
+		ActorID:    record.ActorID,
+		ActorLogin: record.ActorLogin,
+		Action:     record.Action,
+		EntityType: record.EntityType,
+		EntityID:   record.EntityID,
+		Changes:    json.RawMessage(record.Changes),
+		RequestID:  record.RequestID,
+		CreatedAt:  record.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,444 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/auth"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidCredentials  = apperror.New(apperror.Unauthorized, "invalid credentials")
+	ErrAccountDisabled     = apperror.New(apperror.Forbidden, "account is disabled")
+	ErrInvalidRefreshToken = apperror.New(apperror.Unauthorized, "invalid refresh token")
+	ErrInvalidToken        = apperror.New(apperror.Unauthorized, "invalid token")
+	ErrInvalidMFAToken     = apperror.New(apperror.Unauthorized, "invalid or expired MFA token")
+)
+
+// ClientInfo describes the client a session is opened for.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// TokenPair is the result of a login or a refresh.
+type TokenPair struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+	User         *models.User
+	Permissions  []string
+}
+
+// MFAChallenge is returned by Login instead of tokens when the login has
+// to be completed with a second factor.
+type MFAChallenge struct {
+	Token     string
+	ExpiresIn time.Duration
+	// Enroll is set when the role of the user requires a second factor
+	// that the user has not set up yet.
+	Enroll bool
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	UserID    uint
+	Role      string
+	SessionID uint
+	// ScopeNodeID is the root of the subtree the role is restricted to.
+	ScopeNodeID *uint
+}
+
+type AuthService struct {
+	users         *repository.UserRepository
+	sessions      *repository.SessionRepository
+	attempts      *repository.LoginAttemptRepository
+	roles         *RoleService
+	mfa           *MFAService
+	authenticator Authenticator
+	keys          *auth.KeySet
+}
+
+func NewAuthService(users *repository.UserRepository, sessions *repository.SessionRepository, attempts *repository.LoginAttemptRepository, roles *RoleService, mfa *MFAService, authenticator Authenticator, keys *auth.KeySet) *AuthService {
+	return &AuthService{users: users, sessions: sessions, attempts: attempts, roles: roles, mfa: mfa, authenticator: authenticator, keys: keys}
+}
+
+// Login checks the credentials with the configured Authenticator and opens a
+// new session. Users with a second factor, or whose role requires one, get
+// an MFAChallenge instead, to be completed with CompleteMFA.
+//
+// Every attempt is recorded. Repeated failures for the same login or from
+// the same client delay further attempts and eventually lock them out for a
+// while, which is reported with a *ThrottledError.
+func (s *AuthService) Login(login, password string, client ClientInfo) (*TokenPair, *MFAChallenge, error) {
+	policies := throttlePolicies(login, client)
+	counted, err := reserveAttempt(s.attempts, policies)
+	if err != nil {
+		if recordErr := s.recordAttempt(login, nil, client, models.LoginFailureThrottled); recordErr != nil {
+			return nil, nil, recordErr
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.authenticator.Authenticate(login, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, nil, s.loginFailed(login, userIDOf(user), client, policies, counted, models.LoginFailureInvalidCredentials, ErrInvalidCredentials)
+	}
+	if releaseErr := releaseAttempt(s.attempts, policies); releaseErr != nil {
+		return nil, nil, releaseErr
+	}
+	switch {
+	case errors.Is(err, ErrNoRoleMapping):
+		if recordErr := s.recordAttempt(login, userIDOf(user), client, models.LoginFailureNoRole); recordErr != nil {
+			return nil, nil, recordErr
+		}
+		return nil, nil, err
+	case err != nil:
+		return nil, nil, err
+	}
+	return s.completeLogin(user, client)
+}
+
+// completeLogin finishes a login whose credentials have been checked: it
+// refuses disabled users, challenges for a second factor if need be and
+// otherwise opens the session.
+func (s *AuthService) completeLogin(user *models.User, client ClientInfo) (*TokenPair, *MFAChallenge, error) {
+	if user.Disabled {
+		if err := s.recordAttempt(user.Login, &user.ID, client, models.LoginFailureAccountDisabled); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrAccountDisabled
+	}
+
+	required, err := s.mfa.Required(user)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.TOTPEnabled || required {
+		token, err := auth.GenerateMFAToken(user.ID, s.keys)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, &MFAChallenge{Token: token, ExpiresIn: auth.MFATokenExpiration, Enroll: !user.TOTPEnabled}, nil
+	}
+
+	tokens, err := s.loginSucceeded(user, client)
+	return tokens, nil, err
+}
+
+// CompleteMFA finishes a login challenged by Login with a TOTP or recovery
+// code. For a user who has just enrolled through StartMFAEnrollment the code
+// confirms the enrollment, and the new recovery codes are returned.
+func (s *AuthService) CompleteMFA(mfaToken, code string, client ClientInfo) (*TokenPair, []string, error) {
+	user, err := s.challengedUser(mfaToken)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	policies := throttlePolicies(user.Login, client)
+	counted, err := reserveAttempt(s.attempts, policies)
+	if err != nil {
+		if recordErr := s.recordAttempt(user.Login, &user.ID, client, models.LoginFailureThrottled); recordErr != nil {
+			return nil, nil, recordErr
+		}
+		return nil, nil, err
+	}
+
+	var recoveryCodes []string
+	if user.TOTPEnabled {
+		err = s.mfa.Verify(user, code)
+	} else {
+		recoveryCodes, err = s.mfa.ConfirmEnrollment(user.ID, code)
+	}
+	if errors.Is(err, ErrInvalidMFACode) {
+		return nil, nil, s.loginFailed(user.Login, &user.ID, client, policies, counted, models.LoginFailureInvalidMFACode, ErrInvalidMFACode)
+	}
+	if releaseErr := releaseAttempt(s.attempts, policies); releaseErr != nil {
+		return nil, nil, releaseErr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, err := s.loginSucceeded(user, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokens, recoveryCodes, nil
+}
+
+// StartMFAEnrollment generates a TOTP secret for a challenged user whose
+// role requires a second factor that has not been set up yet.
+func (s *AuthService) StartMFAEnrollment(mfaToken string) (*TOTPEnrollment, error) {
+	user, err := s.challengedUser(mfaToken)
+	if err != nil {
+		return nil, err
+	}
+	return s.mfa.StartEnrollment(user.ID)
+}
+
+// challengedUser returns the enabled user an MFA token was issued to.
+func (s *AuthService) challengedUser(mfaToken string) (*models.User, error) {
+	claims, err := auth.ParseJWT(mfaToken, s.keys)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	userID, okUser := claims["sub"].(float64)
+	if typ, _ := claims["typ"].(string); typ != auth.MFATokenType || !okUser {
+		return nil, ErrInvalidMFAToken
+	}
+
+	user, err := s.users.GetByID(uint(userID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidMFAToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+	return user, nil
+}
+
+func (s *AuthService) loginSucceeded(user *models.User, client ClientInfo) (*TokenPair, error) {
+	if err := s.attempts.ResetThrottles(loginThrottleKey(user.Login)); err != nil {
+		return nil, err
+	}
+	if err := s.recordAttempt(user.Login, &user.ID, client, ""); err != nil {
+		return nil, err
+	}
+	return s.openSession(user, client)
+}
+
+// loginFailed keeps the attempt counted by reserveAttempt, records it with
+// reason and returns failure.
+func (s *AuthService) loginFailed(login string, userID *uint, client ClientInfo, policies map[string]throttlePolicy, counted []models.LoginThrottle, reason string, failure error) error {
+	if err := lockOut(s.attempts, policies, counted); err != nil {
+		return err
+	}
+	if err := s.recordAttempt(login, userID, client, reason); err != nil {
+		return err
+	}
+	return failure
+}
+
+// userIDOf returns the ID of user for a login attempt, or nil when the login
+// belongs to no user.
+func userIDOf(user *models.User) *uint {
+	if user == nil {
+		return nil
+	}
+	return &user.ID
+}
+
+// recordAttempt stores a login attempt; an empty reason marks a success.
+func (s *AuthService) recordAttempt(login string, userID *uint, client ClientInfo, reason string) error {
+	return s.attempts.Record(&models.LoginAttempt{
+		Login:     login,
+		UserID:    userID,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Success:   reason == "",
+		Reason:    reason,
+	})
+}
+
+// ListLoginAttempts returns one page of login attempts, newest first, and
+// the cursor of the next page, which is 0 on the last one.
+func (s *AuthService) ListLoginAttempts(query *dto.LoginAttemptQuery) ([]models.LoginAttempt, uint, error) {
+	filter := repository.LoginAttemptFilter{
+		Login:   query.Login,
+		UserID:  query.UserID,
+		IP:      query.IP,
+		Success: query.Success,
+		Since:   query.Since,
+		Until:   query.Until,
+		Limit:   query.Limit,
+		Before:  query.Cursor,
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultAuditPageSize
+	}
+
+	attempts, err := s.attempts.List(filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next uint
+	if len(attempts) == filter.Limit {
+		next = attempts[len(attempts)-1].ID
+	}
+	return attempts, next, nil
+}
+
+func (s *AuthService) ToLoginAttemptResponse(attempt *models.LoginAttempt) dto.LoginAttemptResponse {
+	return dto.LoginAttemptResponse{
+		ID:        attempt.ID,
+		Login:     attempt.Login,
+		UserID:    attempt.UserID,
+		IP:        attempt.IP,
+		UserAgent: attempt.UserAgent,
+		Success:   attempt.Success,
+		Reason:    attempt.Reason,
+		CreatedAt: attempt.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// Refresh exchanges a refresh token for a new token pair. The presented
+// token is rotated out; presenting it again revokes the whole session, as it
+// means the token has leaked.
+func (s *AuthService) Refresh(refreshToken string, client ClientInfo) (*TokenPair, error) {
+	hash := auth.HashToken(refreshToken)
+
+	session, err := s.sessions.GetByRefreshHash(hash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if reused, err := s.sessions.GetByPreviousHash(hash); err == nil {
+			if err := s.sessions.Revoke(reused.ID); err != nil {
+				return nil, err
+			}
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	user, err := s.users.GetByID(session.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrAccountDisabled
+	}
+
+	newToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessions.Rotate(session, auth.HashToken(newToken), time.Now().Add(auth.RefreshTokenExpiration)); err != nil {
+		if errors.Is(err, repository.ErrStaleRefreshToken) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	return s.issue(user, session.ID, newToken)
+}
+
+// Logout revokes a single session.
+func (s *AuthService) Logout(sessionID uint) error {
+	return s.sessions.Revoke(sessionID)
+}
+
+// Authenticate validates an access token and the session it belongs to.
+func (s *AuthService) Authenticate(token string) (*Principal, error) {
+	claims, err := auth.ParseJWT(token, s.keys)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	userID, okUser := claims["sub"].(float64)
+	sessionID, okSession := claims["sid"].(float64)
+	role, okRole := claims["role"].(string)
+	if _, typed := claims["typ"]; typed || !okUser || !okSession || !okRole {
+		return nil, ErrInvalidToken
+	}
+
+	var scopeNodeID *uint
+	if value, ok := claims["scope"]; ok {
+		scope, ok := value.(float64)
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		id := uint(scope)
+		scopeNodeID = &id
+	}
+
+	session, err := s.sessions.GetByID(uint(sessionID))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.RevokedAt != nil || session.UserID != uint(userID) {
+		return nil, ErrInvalidToken
+	}
+
+	// The role and the scope are those of the user now rather than those
+	// the token was issued with, so that a demotion takes effect at once.
+	user, err := s.users.GetByID(session.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled || user.Role != role || derefID(user.ScopeNodeID) != derefID(scopeNodeID) {
+		return nil, ErrInvalidToken
+	}
+
+	return &Principal{UserID: user.ID, Role: user.Role, SessionID: session.ID, ScopeNodeID: user.ScopeNodeID}, nil
+}
+
+func (s *AuthService) openSession(user *models.User, client ClientInfo) (*TokenPair, error) {
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session := models.Session{
+		UserID:           user.ID,
+		RefreshTokenHash: auth.HashToken(refreshToken),
+		UserAgent:        client.UserAgent,
+		IP:               client.IP,
+		ExpiresAt:        time.Now().Add(auth.RefreshTokenExpiration),
+	}
+	if err := s.sessions.Create(&session); err != nil {
+		return nil, err
+	}
+
+	return s.issue(user, session.ID, refreshToken)
+}
+
+func (s *AuthService) issue(user *models.User, sessionID uint, refreshToken string) (*TokenPair, error) {
+	accessToken, err := auth.GenerateJWT(user.ID, user.Role, user.ScopeNodeID, sessionID, s.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := s.roles.Permissions(user.Role)
+	if err != nil {
+		return nil, err
+	}
+	if user.ScopeNodeID != nil {
+		scoped := permissions[:0]
+		for _, permission := range permissions {
+			if models.IsSubtreePermission(permission) {
+				scoped = append(scoped, permission)
+			}
+		}
+		permissions = scoped
+	}
+
+	return &TokenPair{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    auth.AccessTokenExpiration,
+		User:         user,
+		Permissions:  permissions,
+	}, nil
+}
@@ -0,0 +1,211 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/auth"
+	"equipment-management/pkg/directory"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrDirectoryUnavailable = apperror.New(apperror.Unavailable, "directory is unavailable, try again later")
+	ErrNoRoleMapping        = apperror.New(apperror.Forbidden, "no role is granted to your directory groups")
+)
+
+// Authenticator checks the password of a login for AuthService.Login.
+type Authenticator interface {
+	// Authenticate returns the user the credentials belong to. When it
+	// fails with ErrInvalidCredentials the user is still returned if the
+	// login exists, so that the attempt can be attributed to it.
+	Authenticate(login, password string) (*models.User, error)
+}
+
+// userStore is the part of repository.UserRepository the authenticators
+// need.
+type userStore interface {
+	GetByLogin(login string) (*models.User, error)
+	Create(user *models.User) error
+	Update(user *models.User, columns map[string]interface{}) error
+}
+
+// roleChecker is the part of RoleService that mapping groups to roles needs.
+type roleChecker interface {
+	RoleExists(name string) (bool, error)
+}
+
+// LocalAuthenticator checks passwords against the hashes stored with the
+// users.
+type LocalAuthenticator struct {
+	users userStore
+}
+
+func NewLocalAuthenticator(users *repository.UserRepository) *LocalAuthenticator {
+	return &LocalAuthenticator{users: users}
+}
+
+func (a *LocalAuthenticator) Authenticate(login, password string) (*models.User, error) {
+	user, err := a.users.GetByLogin(login)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.AuthSource != models.AuthSourceLocal || !auth.CheckPasswordHash(password, user.Password) {
+		return user, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// GroupRole grants Role to the members of a directory group, given by its
+// distinguished name.
+type GroupRole struct {
+	Group string
+	Role  string
+}
+
+// ParseGroupRoles reads group mappings written as "group DN:role" and
+// separated by semicolons.
+func ParseGroupRoles(value string) ([]GroupRole, error) {
+	var mappings []GroupRole
+	for _, item := range strings.Split(value, ";") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i <= 0 || i == len(item)-1 {
+			return nil, fmt.Errorf("invalid group mapping %q, want \"group DN:role\"", item)
+		}
+		mappings = append(mappings, GroupRole{
+			Group: strings.TrimSpace(item[:i]),
+			Role:  strings.TrimSpace(item[i+1:]),
+		})
+	}
+	return mappings, nil
+}
+
+// groupRoleMapper decides the role of a user of an external source from the
+// groups the source reports for them.
+type groupRoleMapper struct {
+	roles roleChecker
+	// groupRoles is checked in order; the first group the user is a member
+	// of decides the role, defaultRole applies when none matches.
+	groupRoles  []GroupRole
+	defaultRole string
+}
+
+// role returns the role granted by the first mapped group found among
+// groups. Mappings to roles that no longer exist are skipped.
+func (m groupRoleMapper) role(groups []string) (string, error) {
+	for _, mapping := range m.groupRoles {
+		for _, group := range groups {
+			if !strings.EqualFold(group, mapping.Group) {
+				continue
+			}
+			exists, err := m.roles.RoleExists(mapping.Role)
+			if err != nil {
+				return "", err
+			}
+			if exists {
+				return mapping.Role, nil
+			}
+		}
+	}
+	if m.defaultRole != "" {
+		return m.defaultRole, nil
+	}
+	return "", ErrNoRoleMapping
+}
+
+// syncExternalUser creates the user of an external account on its first
+// login, or else brings the role of the existing user up to date.
+func syncExternalUser(users userStore, user *models.User, login, role, source string) (*models.User, error) {
+	if user == nil {
+		user = &models.User{Login: login, Role: role, AuthSource: source}
+		if err := users.Create(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+	if user.Role != role {
+		if err := users.Update(user, map[string]interface{}{"role": role}); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// LDAPAuthenticator checks passwords against a directory. Users are created
+// on their first login, and their role follows their groups on every login.
+// Users created locally, such as service accounts or an emergency admin,
+// keep logging in with their own password.
+type LDAPAuthenticator struct {
+	directory directory.Directory
+	local     *LocalAuthenticator
+	users     userStore
+	mapper    groupRoleMapper
+}
+
+func NewLDAPAuthenticator(dir directory.Directory, users *repository.UserRepository, roles *RoleService, groupRoles []GroupRole, defaultRole string) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		directory: dir,
+		local:     NewLocalAuthenticator(users),
+		users:     users,
+		mapper:    groupRoleMapper{roles: roles, groupRoles: groupRoles, defaultRole: defaultRole},
+	}
+}
+
+func (a *LDAPAuthenticator) Authenticate(login, password string) (*models.User, error) {
+	// Directory logins are case-insensitive, so they are stored in lower
+	// case to keep one user per account.
+	name := strings.ToLower(strings.TrimSpace(login))
+
+	user, err := a.findUser(login, name)
+	if err != nil {
+		return nil, err
+	}
+	if user != nil && user.AuthSource == models.AuthSourceLocal {
+		return a.local.Authenticate(user.Login, password)
+	}
+	// Accounts of another source, such as single sign-on, are not taken
+	// over by a directory account of the same name.
+	if user != nil && user.AuthSource != models.AuthSourceLDAP {
+		return user, ErrInvalidCredentials
+	}
+
+	entry, err := a.directory.Authenticate(name, password)
+	if errors.Is(err, directory.ErrInvalidCredentials) {
+		return user, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+
+	role, err := a.mapper.role(entry.Groups)
+	if err != nil {
+		return user, err
+	}
+	return syncExternalUser(a.users, user, name, role, models.AuthSourceLDAP)
+}
+
+// findUser looks the login up as typed, which is how local users are found,
+// and then in the lower case of directory users.
+func (a *LDAPAuthenticator) findUser(login, name string) (*models.User, error) {
+	for _, candidate := range []string{login, name} {
+		user, err := a.users.GetByLogin(candidate)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
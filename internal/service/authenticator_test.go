@@ -0,0 +1,293 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"equipment-management/internal/models"
+	"equipment-management/pkg/auth"
+	"equipment-management/pkg/directory"
+
+	"gorm.io/gorm"
+)
+
+// fakeDirectory is an in-process stand-in for an LDAP directory.
+type fakeDirectory struct {
+	// accounts maps logins to passwords and groups.
+	accounts map[string]fakeAccount
+	// err, when set, is returned for every lookup.
+	err   error
+	calls int
+}
+
+type fakeAccount struct {
+	password string
+	groups   []string
+}
+
+func (d *fakeDirectory) Authenticate(login, password string) (*directory.Entry, error) {
+	d.calls++
+	if d.err != nil {
+		return nil, d.err
+	}
+	account, ok := d.accounts[login]
+	if !ok || account.password != password {
+		return nil, directory.ErrInvalidCredentials
+	}
+	return &directory.Entry{DN: "uid=" + login + ",ou=people,dc=example,dc=com", Groups: account.groups}, nil
+}
+
+// fakeUsers keeps users in memory, keyed by login.
+type fakeUsers struct {
+	users  map[string]*models.User
+	nextID uint
+}
+
+func newFakeUsers(users ...*models.User) *fakeUsers {
+	store := &fakeUsers{users: make(map[string]*models.User), nextID: 1}
+	for _, user := range users {
+		if err := store.Create(user); err != nil {
+			panic(err)
+		}
+	}
+	return store
+}
+
+func (f *fakeUsers) GetByLogin(login string) (*models.User, error) {
+	user, ok := f.users[login]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return user, nil
+}
+
+func (f *fakeUsers) GetByOIDCSubject(issuer, subject string) (*models.User, error) {
+	for _, user := range f.users {
+		if user.OIDCIssuer != nil && *user.OIDCIssuer == issuer && user.OIDCSubject != nil && *user.OIDCSubject == subject {
+			return user, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (f *fakeUsers) Create(user *models.User) error {
+	user.ID = f.nextID
+	f.nextID++
+	f.users[user.Login] = user
+	return nil
+}
+
+func (f *fakeUsers) Update(user *models.User, columns map[string]interface{}) error {
+	for column, value := range columns {
+		switch column {
+		case "role":
+			user.Role = value.(string)
+		case "login":
+			delete(f.users, user.Login)
+			user.Login = value.(string)
+			f.users[user.Login] = user
+		default:
+			return errors.New("fakeUsers cannot update " + column)
+		}
+	}
+	return nil
+}
+
+// fakeRoles lists the roles that exist.
+type fakeRoles map[string]bool
+
+func (r fakeRoles) RoleExists(name string) (bool, error) {
+	return r[name], nil
+}
+
+const (
+	adminsGroup    = "cn=admins,ou=groups,dc=example,dc=com"
+	operatorsGroup = "cn=operators,ou=groups,dc=example,dc=com"
+)
+
+func newTestLDAPAuthenticator(dir *fakeDirectory, users *fakeUsers, roles fakeRoles, defaultRole string) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		directory: dir,
+		local:     &LocalAuthenticator{users: users},
+		users:     users,
+		mapper: groupRoleMapper{
+			roles: roles,
+			groupRoles: []GroupRole{
+				{Group: adminsGroup, Role: "admin"},
+				{Group: operatorsGroup, Role: "operator"},
+			},
+			defaultRole: defaultRole,
+		},
+	}
+}
+
+func TestLDAPAuthenticatorRejectsWrongPassword(t *testing.T) {
+	dir := &fakeDirectory{accounts: map[string]fakeAccount{"alice": {password: "secret"}}}
+	users := newFakeUsers()
+	a := newTestLDAPAuthenticator(dir, users, fakeRoles{"viewer": true}, "viewer")
+
+	user, err := a.Authenticate("alice", "wrong")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if user != nil || len(users.users) != 0 {
+		t.Fatalf("a failed login must not create a user, got %+v", user)
+	}
+}
+
+func TestLDAPAuthenticatorReportsUnavailableDirectory(t *testing.T) {
+	dir := &fakeDirectory{err: directory.ErrUnavailable}
+	a := newTestLDAPAuthenticator(dir, newFakeUsers(), fakeRoles{"viewer": true}, "viewer")
+
+	if _, err := a.Authenticate("alice", "secret"); !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("got %v, want ErrDirectoryUnavailable", err)
+	}
+}
+
+func TestLDAPAuthenticatorProvisionsUserOnFirstLogin(t *testing.T) {
+	dir := &fakeDirectory{accounts: map[string]fakeAccount{
+		"alice": {password: "secret", groups: []string{strings.ToUpper(operatorsGroup)}},
+	}}
+	users := newFakeUsers()
+	a := newTestLDAPAuthenticator(dir, users, fakeRoles{"operator": true, "viewer": true}, "viewer")
+
+	user, err := a.Authenticate("  Alice ", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Login != "alice" || user.AuthSource != models.AuthSourceLDAP || user.Role != "operator" {
+		t.Fatalf("got %+v, want the ldap user alice with role operator", user)
+	}
+	if users.users["alice"] != user {
+		t.Fatal("the user was not stored under the lower-case login")
+	}
+
+	again, err := a.Authenticate("ALICE", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID || len(users.users) != 1 {
+		t.Fatal("a second login must find the provisioned user instead of creating another")
+	}
+}
+
+func TestLDAPAuthenticatorMapsGroupsToRoles(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      []string
+		roles       fakeRoles
+		defaultRole string
+		want        string
+		wantErr     error
+	}{
+		{
+			name:   "first mapping wins",
+			groups: []string{operatorsGroup, adminsGroup},
+			roles:  fakeRoles{"admin": true, "operator": true},
+			want:   "admin",
+		},
+		{
+			name:        "deleted role falls through to the next mapping",
+			groups:      []string{adminsGroup, operatorsGroup},
+			roles:       fakeRoles{"operator": true, "viewer": true},
+			defaultRole: "viewer",
+			want:        "operator",
+		},
+		{
+			name:        "deleted role falls back to the default role",
+			groups:      []string{operatorsGroup},
+			roles:       fakeRoles{"viewer": true},
+			defaultRole: "viewer",
+			want:        "viewer",
+		},
+		{
+			name:        "unmapped groups get the default role",
+			groups:      []string{"cn=staff,ou=groups,dc=example,dc=com"},
+			roles:       fakeRoles{"viewer": true},
+			defaultRole: "viewer",
+			want:        "viewer",
+		},
+		{
+			name:    "no mapping and no default role",
+			groups:  []string{"cn=staff,ou=groups,dc=example,dc=com"},
+			roles:   fakeRoles{"viewer": true},
+			wantErr: ErrNoRoleMapping,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := &fakeDirectory{accounts: map[string]fakeAccount{"bob": {password: "secret", groups: tt.groups}}}
+			a := newTestLDAPAuthenticator(dir, newFakeUsers(), tt.roles, tt.defaultRole)
+
+			user, err := a.Authenticate("bob", "secret")
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != tt.want {
+				t.Fatalf("role = %q, want %q", user.Role, tt.want)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorUpdatesRoleOfExistingUser(t *testing.T) {
+	existing := &models.User{Login: "carol", Role: "operator", AuthSource: models.AuthSourceLDAP}
+	dir := &fakeDirectory{accounts: map[string]fakeAccount{"carol": {password: "secret", groups: []string{adminsGroup}}}}
+	a := newTestLDAPAuthenticator(dir, newFakeUsers(existing), fakeRoles{"admin": true, "operator": true}, "")
+
+	user, err := a.Authenticate("carol", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != existing || user.Role != "admin" {
+		t.Fatalf("got %+v, want the existing user promoted to admin", user)
+	}
+}
+
+func TestLDAPAuthenticatorKeepsLocalAccounts(t *testing.T) {
+	hash, err := auth.HashPassword("local-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := &models.User{Login: "admin", Password: hash, Role: "admin", AuthSource: models.AuthSourceLocal}
+	// The directory knows an account of the same name with another password.
+	dir := &fakeDirectory{accounts: map[string]fakeAccount{"admin": {password: "directory-secret"}}}
+	a := newTestLDAPAuthenticator(dir, newFakeUsers(local), fakeRoles{"admin": true, "viewer": true}, "viewer")
+
+	if _, err := a.Authenticate("admin", "directory-secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("directory password: got %v, want ErrInvalidCredentials", err)
+	}
+	user, err := a.Authenticate("admin", "local-secret")
+	if err != nil {
+		t.Fatalf("local password: %v", err)
+	}
+	if user != local || user.AuthSource != models.AuthSourceLocal || user.Role != "admin" {
+		t.Fatalf("got %+v, want the unchanged local user", user)
+	}
+	if dir.calls != 0 {
+		t.Fatalf("the directory was asked %d times about a local account", dir.calls)
+	}
+}
+
+func TestLDAPAuthenticatorKeepsSSOAccounts(t *testing.T) {
+	sso := &models.User{Login: "dave", Role: "viewer", AuthSource: models.AuthSourceOIDC}
+	dir := &fakeDirectory{accounts: map[string]fakeAccount{"dave": {password: "secret", groups: []string{adminsGroup}}}}
+	a := newTestLDAPAuthenticator(dir, newFakeUsers(sso), fakeRoles{"admin": true, "viewer": true}, "viewer")
+
+	if _, err := a.Authenticate("dave", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if sso.AuthSource != models.AuthSourceOIDC || sso.Role != "viewer" {
+		t.Fatalf("the SSO account was taken over: %+v", sso)
+	}
+	if dir.calls != 0 {
+		t.Fatalf("the directory was asked %d times about an SSO account", dir.calls)
+	}
+}
@@ -4,18 +4,26 @@ import (
 	"equipment-management/internal/dto"
 	"equipment-management/internal/models"
 	"equipment-management/internal/repository"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
 type DeviceService struct {
-	repo *repository.DeviceRepository
+	repo     *repository.DeviceRepository
+	nodeRepo *repository.NetworkNodeRepository
 }
 
-func NewDeviceService(repo *repository.DeviceRepository) *DeviceService {
-	return &DeviceService{repo: repo}
+func NewDeviceService(repo *repository.DeviceRepository, nodeRepo *repository.NetworkNodeRepository) *DeviceService {
+	return &DeviceService{repo: repo, nodeRepo: nodeRepo}
 }
 
-func (s *DeviceService) CreateDevice(req *dto.CreateDeviceRequest) (*models.Device, error) {
+func (s *DeviceService) CreateDevice(actor repository.Actor, req *dto.CreateDeviceRequest) (*models.Device, error) {
+	if err := requireUsableNode(s.nodeRepo, actor, req.NetworkNodeID); err != nil {
+		return nil, err
+	}
+
 	device := models.Device{
 		Type:          req.Type,
 		Vendor:        req.Vendor,
@@ -23,40 +31,181 @@ func (s *DeviceService) CreateDevice(req *dto.CreateDeviceRequest) (*models.Devi
 		Serial:        req.Serial,
 		Location:      req.Location,
 		NetworkNodeID: req.NetworkNodeID,
-		Status:        "active",
+		Status:        models.DeviceStatusActive,
+	}
+	if req.Status != "" {
+		if !initialDeviceStatuses[req.Status] {
+			return nil, ErrInvalidInitialStatus
+		}
+		device.Status = req.Status
 	}
 
-	if err := s.repo.Create(&device); err != nil {
+	if err := s.repo.Create(actor, &device); err != nil {
 		return nil, err
 	}
 
 	return &device, nil
 }
 
-func (s *DeviceService) GetDevice(id uint) (*models.Device, error) {
-	return s.repo.GetByID(id)
+// GetDevice returns the device unless it is outside of the actor's scope,
+// in which case it is reported as not found.
+func (s *DeviceService) GetDevice(actor repository.Actor, id uint) (*models.Device, error) {
+	device, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	inside, err := nodeInScope(s.nodeRepo, actor, device.NetworkNodeID)
+	if err != nil {
+		return nil, err
+	}
+	if !inside {
+		return nil, repository.ErrDeviceNotFound
+	}
+	return device, nil
 }
 
-func (s *DeviceService) UpdateDevice(id uint, req *dto.UpdateDeviceRequest) (*models.Device, error) {
+// CheckDevice fails with repository.ErrDeviceNotFound when the device is outside
+// of the actor's scope. Unrestricted actors are not checked at all.
+func (s *DeviceService) CheckDevice(actor repository.Actor, id uint) error {
+	if actor.Scope == nil {
+		return nil
+	}
+	_, err := s.GetDevice(actor, id)
+	return err
+}
+
+// UpdateDevice replaces the editable fields of the device if it is still at
+// version, which is 0 to overwrite whatever version it is at.
+func (s *DeviceService) UpdateDevice(actor repository.Actor, id, version uint, req *dto.UpdateDeviceRequest) (*models.Device, error) {
+	if err := s.CheckDevice(actor, id); err != nil {
+		return nil, err
+	}
+	return s.replaceDevice(actor, id, version, req)
+}
+
+// PatchDevice applies a JSON merge patch to the editable fields of the
+// device if it is still at version, which is 0 to patch whatever version
+// it is at. A null member clears the field.
+func (s *DeviceService) PatchDevice(actor repository.Actor, id, version uint, patch []byte) (*models.Device, error) {
+	device, err := s.GetDevice(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && device.Version != version {
+		return nil, repository.ErrVersionMismatch
+	}
+
+	current := dto.UpdateDeviceRequest{
+		Type:          device.Type,
+		Vendor:        device.Vendor,
+		Model:         device.Model,
+		Serial:        device.Serial,
+		Location:      device.Location,
+		NetworkNodeID: device.NetworkNodeID,
+	}
+	req, err := applyMergePatch(&current, patch)
+	if err != nil {
+		return nil, err
+	}
+	// The patch was computed against this version, so it must not be
+	// applied over a later one.
+	return s.replaceDevice(actor, id, device.Version, req)
+}
+
+func (s *DeviceService) replaceDevice(actor repository.Actor, id, version uint, req *dto.UpdateDeviceRequest) (*models.Device, error) {
+	if err := requireUsableNode(s.nodeRepo, actor, req.NetworkNodeID); err != nil {
+		return nil, err
+	}
+
 	updateData := models.Device{
 		Type:          req.Type,
 		Vendor:        req.Vendor,
 		Model:         req.Model,
 		Serial:        req.Serial,
 		Location:      req.Location,
-		Status:        req.Status,
 		NetworkNodeID: req.NetworkNodeID,
 	}
+	return s.repo.Update(actor, id, version, &updateData)
+}
+
+// TransitionDevice moves the device to another lifecycle status and records
+// the transition.
+func (s *DeviceService) TransitionDevice(actor repository.Actor, id uint, req *dto.DeviceTransitionRequest) (*models.Device, *models.DeviceStatusTransition, error) {
+	if err := s.CheckDevice(actor, id); err != nil {
+		return nil, nil, err
+	}
+	return s.repo.Transition(actor, id, func(device *models.Device) (*models.DeviceStatusTransition, error) {
+		return newTransition(device, req.Status, req.Reason)
+	})
+}
+
+func (s *DeviceService) GetTransitions(actor repository.Actor, id uint) ([]models.DeviceStatusTransition, error) {
+	if _, err := s.GetDevice(actor, id); err != nil {
+		return nil, err
+	}
+	return s.repo.GetTransitions(id)
+}
 
-	return s.repo.Update(id, &updateData)
+func (s *DeviceService) DeleteDevice(actor repository.Actor, id uint) error {
+	if err := s.CheckDevice(actor, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(actor, id)
 }
 
-func (s *DeviceService) DeleteDevice(id uint) error {
-	return s.repo.Delete(id)
+const DefaultDevicePageSize = 100
+
+// ListDevices returns one page of the devices within the actor's scope that
+// match query, the total number of matching devices and the cursor of the
+// next page.
+func (s *DeviceService) ListDevices(actor repository.Actor, query *dto.DeviceListQuery) ([]models.Device, int64, string, error) {
+	filter, err := s.toDeviceFilter(actor, query)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return s.repo.List(filter)
 }
 
-func (s *DeviceService) GetAllDevices() ([]models.Device, error) {
-	return s.repo.GetAll()
+func (s *DeviceService) toDeviceFilter(actor repository.Actor, query *dto.DeviceListQuery) (repository.DeviceFilter, error) {
+	filter := repository.DeviceFilter{
+		ScopeNodeID: actor.Scope,
+		Type:        query.Type,
+		Vendor:      query.Vendor,
+		Model:       query.Model,
+		Status:      query.Status,
+		Location:    query.Location,
+		Search:      strings.TrimSpace(query.Search),
+		SortColumn:  strings.TrimPrefix(query.Sort, "-"),
+		SortDesc:    strings.HasPrefix(query.Sort, "-"),
+		Limit:       query.Limit,
+		Cursor:      query.Cursor,
+	}
+
+	if filter.SortColumn == "" {
+		filter.SortColumn = "id"
+	}
+	if !repository.IsDeviceSortColumn(filter.SortColumn) {
+		return filter, repository.ErrInvalidFilter.Because(fmt.Sprintf("unknown sort column %q", filter.SortColumn))
+	}
+	if filter.Limit == 0 {
+		filter.Limit = DefaultDevicePageSize
+	}
+
+	switch query.NetworkNodeID {
+	case "":
+	case "unassigned":
+		filter.Unassigned = true
+	default:
+		id, err := strconv.ParseUint(query.NetworkNodeID, 10, 32)
+		if err != nil {
+			return filter, repository.ErrInvalidFilter.Because("invalid network_node_id")
+		}
+		nodeID := uint(id)
+		filter.NetworkNodeID = &nodeID
+	}
+
+	return filter, nil
 }
 
 func (s *DeviceService) ToDeviceResponse(device *models.Device) dto.DeviceResponse {
@@ -69,7 +218,19 @@ func (s *DeviceService) ToDeviceResponse(device *models.Device) dto.DeviceRespon
 		Location:      device.Location,
 		Status:        device.Status,
 		NetworkNodeID: device.NetworkNodeID,
+		Version:       device.Version,
 		CreatedAt:     device.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:     device.UpdatedAt.Format(time.RFC3339),
 	}
 }
+
+func (s *DeviceService) ToTransitionResponse(transition *models.DeviceStatusTransition) dto.DeviceTransitionResponse {
+	return dto.DeviceTransitionResponse{
+		ID:         transition.ID,
+		DeviceID:   derefID(transition.DeviceID),
+		FromStatus: transition.FromStatus,
+		ToStatus:   transition.ToStatus,
+		Reason:     transition.Reason,
+		CreatedAt:  transition.CreatedAt.Format(time.RFC3339),
+	}
+}
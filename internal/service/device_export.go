@@ -0,0 +1,168 @@
+package service
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/xlsx"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var ErrUnsupportedExportFormat = apperror.New(apperror.BadRequest, "unsupported export format")
+
+// Export formats accepted by ExportDevices.
+const (
+	ExportFormatCSV   = "csv"
+	ExportFormatJSONL = "jsonl"
+	ExportFormatXLSX  = "xlsx"
+)
+
+var exportColumns = []string{
+	"id", "type", "vendor", "model", "serial", "location", "status",
+	"network_node_id", "network_node_path", "created_at", "updated_at",
+}
+
+// deviceRowWriter encodes exported devices one at a time.
+type deviceRowWriter interface {
+	Write(device dto.DeviceResponse) error
+	Close() error
+}
+
+// ExportDevices writes every device within the actor's scope that matches
+// query to w in the given format. Devices are streamed from the database
+// rather than loaded at once.
+func (s *DeviceService) ExportDevices(actor repository.Actor, query *dto.DeviceListQuery, format string, w io.Writer) error {
+	filter, err := s.toDeviceFilter(actor, query)
+	if err != nil {
+		return err
+	}
+
+	nodes, err := s.nodeRepo.GetAll()
+	if err != nil {
+		return err
+	}
+	paths := buildBreadcrumbs(scopedNodes(actor, nodes))
+
+	out, err := newDeviceRowWriter(format, w)
+	if err != nil {
+		return err
+	}
+
+	err = s.repo.Stream(filter, func(device *models.Device) error {
+		row := s.ToDeviceResponse(device)
+		if device.NetworkNodeID != nil {
+			row.NetworkNodePath = paths[*device.NetworkNodeID]
+		}
+		return out.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// ValidExportFormat reports whether ExportDevices supports format.
+func ValidExportFormat(format string) bool {
+	switch format {
+	case ExportFormatCSV, ExportFormatJSONL, ExportFormatXLSX:
+		return true
+	}
+	return false
+}
+
+func newDeviceRowWriter(format string, w io.Writer) (deviceRowWriter, error) {
+	switch format {
+	case ExportFormatCSV:
+		out := &csvRowWriter{w: csv.NewWriter(w)}
+		return out, out.w.Write(exportColumns)
+	case ExportFormatJSONL:
+		return &jsonlRowWriter{enc: json.NewEncoder(w)}, nil
+	case ExportFormatXLSX:
+		sheet, err := xlsx.NewWriter(w, "Devices")
+		if err != nil {
+			return nil, err
+		}
+		return &xlsxRowWriter{w: sheet}, sheet.WriteRow(exportColumns)
+	}
+	return nil, ErrUnsupportedExportFormat
+}
+
+func exportRecord(device dto.DeviceResponse) []string {
+	nodeID := ""
+	if device.NetworkNodeID != nil {
+		nodeID = strconv.FormatUint(uint64(*device.NetworkNodeID), 10)
+	}
+	return []string{
+		strconv.FormatUint(uint64(device.ID), 10),
+		device.Type,
+		device.Vendor,
+		device.Model,
+		device.Serial,
+		device.Location,
+		device.Status,
+		nodeID,
+		device.NetworkNodePath,
+		device.CreatedAt,
+		device.UpdatedAt,
+	}
+}
+
+type csvRowWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvRowWriter) Write(device dto.DeviceResponse) error {
+	record := exportRecord(device)
+	for i, field := range record {
+		record[i] = neutralizeFormula(field)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// formulaPrefixes are the characters that make spreadsheets read a CSV
+// field as a formula.
+const formulaPrefixes = "=+-@\t\r"
+
+// neutralizeFormula prefixes a field that a spreadsheet would evaluate with
+// an apostrophe, so that a device field like "=HYPERLINK(...)" opens as
+// text.
+func neutralizeFormula(field string) string {
+	if field != "" && strings.ContainsRune(formulaPrefixes, rune(field[0])) {
+		return "'" + field
+	}
+	return field
+}
+
+type jsonlRowWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlRowWriter) Write(device dto.DeviceResponse) error {
+	return j.enc.Encode(device)
+}
+
+func (j *jsonlRowWriter) Close() error {
+	return nil
+}
+
+type xlsxRowWriter struct {
+	w *xlsx.Writer
+}
+
+func (x *xlsxRowWriter) Write(device dto.DeviceResponse) error {
+	return x.w.WriteRow(exportRecord(device))
+}
+
+func (x *xlsxRowWriter) Close() error {
+	return x.w.Close()
+}
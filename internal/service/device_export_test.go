@@ -0,0 +1,94 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+
+	"equipment-management/internal/dto"
+)
+
+// formulaDevice has a formula, or what starts like one, in its text fields.
+var formulaDevice = dto.DeviceResponse{
+	ID:              1,
+	Type:            "=HYPERLINK(\"http://evil.example\",\"click\")",
+	Vendor:          "+cmd|' /C calc'!A0",
+	Model:           "-2+3",
+	Serial:          "@SUM(A1:A2)",
+	Location:        "\tRack 1",
+	Status:          "active",
+	NetworkNodePath: "DC / Row = 1",
+}
+
+func exportDevice(t *testing.T, format string, device dto.DeviceResponse) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	out, err := newDeviceRowWriter(format, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Write(device); err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCSVExportNeutralizesFormulas(t *testing.T) {
+	records, err := csv.NewReader(bytes.NewReader(exportDevice(t, ExportFormatCSV, formulaDevice))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d records, want the header and one device", len(records))
+	}
+
+	want := []string{
+		"1",
+		"'=HYPERLINK(\"http://evil.example\",\"click\")",
+		"'+cmd|' /C calc'!A0",
+		"'-2+3",
+		"'@SUM(A1:A2)",
+		"'\tRack 1",
+		"active",
+		"",
+		"DC / Row = 1",
+		"",
+		"",
+	}
+	for i, field := range records[1] {
+		if field != want[i] {
+			t.Errorf("%s = %q, want %q", exportColumns[i], field, want[i])
+		}
+	}
+}
+
+func TestXLSXExportWritesTextCells(t *testing.T) {
+	data := exportDevice(t, ExportFormatXLSX, formulaDevice)
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sheet, err := archive.Open("xl/worksheets/sheet1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sheet.Close()
+	content, err := io.ReadAll(sheet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xml := string(content)
+	if strings.Contains(xml, "<f>") {
+		t.Fatal("the sheet contains a formula")
+	}
+	if !strings.Contains(xml, `t="inlineStr"><is><t xml:space="preserve">=HYPERLINK(&#34;http://evil.example&#34;,&#34;click&#34;)</t>`) {
+		t.Fatalf("the type is not written as a text cell:\n%s", xml)
+	}
+}
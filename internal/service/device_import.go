@@ -0,0 +1,295 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+var ErrInvalidImportFile = apperror.New(apperror.BadRequest, "invalid import file")
+
+// importColumns are the CSV columns understood by ImportDevices. A node is
+// referenced either by network_node_id or by network_node, which holds an ID
+// or a path of node names separated by "/".
+var importColumns = map[string]bool{
+	"type":            true,
+	"vendor":          true,
+	"model":           true,
+	"serial":          true,
+	"location":        true,
+	"status":          true,
+	"network_node_id": true,
+	"network_node":    true,
+}
+
+// ImportDevices validates every row of a CSV file and, unless dryRun is set
+// and provided all rows are valid, creates the devices in one transaction.
+// Nothing is created when any row is invalid.
+func (s *DeviceService) ImportDevices(actor repository.Actor, file io.Reader, dryRun bool) (*dto.DeviceImportResponse, error) {
+	rows, err := readImportRows(file)
+	if err != nil {
+		return nil, err
+	}
+
+	resolveNode, err := s.nodeResolver(actor)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &dto.DeviceImportResponse{
+		DryRun: dryRun,
+		Total:  len(rows),
+		Rows:   make([]dto.DeviceImportRow, len(rows)),
+	}
+	devices := make([]models.Device, len(rows))
+	firstLine := make(map[string]int, len(rows))
+
+	for i, row := range rows {
+		result := &report.Rows[i]
+		result.Line = row.line
+		result.Serial = row.fields["serial"]
+
+		req, errs := parseImportRow(row.fields, resolveNode)
+		if actor.Scope != nil && row.fields["network_node_id"] == "" && row.fields["network_node"] == "" {
+			errs = append(errs, "network node is required")
+		}
+		if prev, ok := firstLine[req.Serial]; ok && req.Serial != "" {
+			errs = append(errs, fmt.Sprintf("serial %q duplicates line %d", req.Serial, prev))
+		} else if req.Serial != "" {
+			firstLine[req.Serial] = row.line
+		}
+
+		devices[i] = models.Device{
+			Type:          req.Type,
+			Vendor:        req.Vendor,
+			Model:         req.Model,
+			Serial:        req.Serial,
+			Location:      req.Location,
+			Status:        req.Status,
+			NetworkNodeID: req.NetworkNodeID,
+		}
+		if devices[i].Status == "" {
+			devices[i].Status = models.DeviceStatusActive
+		}
+		result.Errors = errs
+	}
+
+	serials := make([]string, 0, len(firstLine))
+	for serial := range firstLine {
+		serials = append(serials, serial)
+	}
+	existing, err := s.repo.ExistingSerials(serials)
+	if err != nil {
+		return nil, err
+	}
+	taken := make(map[string]bool, len(existing))
+	for _, serial := range existing {
+		taken[serial] = true
+	}
+
+	report.Valid = true
+	for i := range report.Rows {
+		result := &report.Rows[i]
+		if taken[devices[i].Serial] {
+			result.Errors = append(result.Errors, fmt.Sprintf("serial %q already exists", devices[i].Serial))
+		}
+		if len(result.Errors) > 0 {
+			report.Valid = false
+		}
+	}
+
+	if !report.Valid || dryRun {
+		return report, nil
+	}
+
+	if err := s.repo.CreateBatch(actor, devices); err != nil {
+		return nil, err
+	}
+	for i := range devices {
+		report.Rows[i].DeviceID = &devices[i].ID
+	}
+	report.Created = len(devices)
+
+	return report, nil
+}
+
+type importRow struct {
+	line   int
+	fields map[string]string
+}
+
+func readImportRows(file io.Reader) ([]importRow, error) {
+	buffered := bufio.NewReader(file)
+	header, err := buffered.Peek(4096)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+
+	reader := csv.NewReader(buffered)
+	if line, _, _ := bytes.Cut(header, []byte("\n")); bytes.Count(line, []byte(";")) > bytes.Count(line, []byte(",")) {
+		reader.Comma = ';'
+	}
+	reader.TrimLeadingSpace = true
+
+	columns, err := reader.Read()
+	if err == io.EOF {
+		return nil, ErrInvalidImportFile.Because("file is empty")
+	}
+	if err != nil {
+		return nil, csvReadError(err)
+	}
+
+	seen := make(map[string]bool, len(columns))
+	for i, column := range columns {
+		column = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		if !importColumns[column] {
+			return nil, ErrInvalidImportFile.Because(fmt.Sprintf("unknown column %q", column))
+		}
+		if seen[column] {
+			return nil, ErrInvalidImportFile.Because(fmt.Sprintf("duplicate column %q", column))
+		}
+		seen[column] = true
+		columns[i] = column
+	}
+	reader.FieldsPerRecord = len(columns)
+
+	var rows []importRow
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvReadError(err)
+		}
+
+		line, _ := reader.FieldPos(0)
+		fields := make(map[string]string, len(columns))
+		for i, column := range columns {
+			fields[column] = strings.TrimSpace(record[i])
+		}
+		rows = append(rows, importRow{line: line, fields: fields})
+	}
+
+	if len(rows) == 0 {
+		return nil, ErrInvalidImportFile.Because("file has no rows")
+	}
+	return rows, nil
+}
+
+// csvReadError reports a failure of the CSV reader: malformed CSV makes an
+// invalid import file, while a failure to read the upload, such as a body
+// over the size limit, is returned as it is.
+func csvReadError(err error) error {
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return ErrInvalidImportFile.Because(parseErr.Error())
+	}
+	return err
+}
+
+// parseImportRow converts the fields of a row into a device request and
+// lists everything wrong with them.
+func parseImportRow(fields map[string]string, resolveNode func(ref string) (*uint, error)) (dto.CreateDeviceRequest, []string) {
+	req := dto.CreateDeviceRequest{
+		Type:     fields["type"],
+		Vendor:   fields["vendor"],
+		Model:    fields["model"],
+		Serial:   fields["serial"],
+		Location: fields["location"],
+		Status:   fields["status"],
+	}
+
+	var errs []string
+	if err := binding.Validator.ValidateStruct(&req); err != nil {
+		var fieldErrors validator.ValidationErrors
+		if errors.As(err, &fieldErrors) {
+			for _, fieldError := range fieldErrors {
+				errs = append(errs, describeFieldError(fieldError))
+			}
+		} else {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	ref := fields["network_node_id"]
+	if ref != "" && fields["network_node"] != "" {
+		errs = append(errs, "only one of network_node_id and network_node may be set")
+	} else if ref == "" {
+		ref = fields["network_node"]
+	}
+	if ref != "" {
+		var err error
+		if req.NetworkNodeID, err = resolveNode(ref); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	return req, errs
+}
+
+func describeFieldError(err validator.FieldError) string {
+	fieldError := apperror.FieldErrorOf(err)
+	return fieldError.Field + " " + fieldError.Message
+}
+
+// nodeResolver returns a function that finds a network node within the
+// actor's scope by its ID or by its path of names from the root of the scope.
+func (s *DeviceService) nodeResolver(actor repository.Actor) (func(ref string) (*uint, error), error) {
+	nodes, err := s.nodeRepo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	nodes = scopedNodes(actor, nodes)
+
+	ids := make(map[uint]bool, len(nodes))
+	for _, node := range nodes {
+		ids[node.ID] = true
+	}
+
+	byPath := make(map[string][]uint, len(nodes))
+	for id, path := range buildBreadcrumbs(nodes) {
+		key := normalizeNodePath(path)
+		byPath[key] = append(byPath[key], id)
+	}
+
+	return func(ref string) (*uint, error) {
+		if value, err := strconv.ParseUint(ref, 10, 32); err == nil {
+			id := uint(value)
+			if !ids[id] {
+				return nil, fmt.Errorf("network node %d does not exist", id)
+			}
+			return &id, nil
+		}
+
+		matches := byPath[normalizeNodePath(ref)]
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("network node %q does not exist", ref)
+		case 1:
+			return &matches[0], nil
+		default:
+			return nil, fmt.Errorf("network node path %q is ambiguous", ref)
+		}
+	}, nil
+}
+
+func normalizeNodePath(path string) string {
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(part))
+	}
+	return strings.Join(parts, "/")
+}
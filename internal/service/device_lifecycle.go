@@ -0,0 +1,69 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"strings"
+)
+
+var (
+	ErrIllegalTransition    = apperror.New(apperror.Conflict, "status transition is not allowed")
+	ErrReasonRequired       = apperror.New(apperror.Validation, "reason is required for this transition")
+	ErrInvalidInitialStatus = apperror.Invalid("invalid initial status",
+		apperror.FieldError{Field: "status", Message: "must be one of: ordered, in_stock, active"})
+)
+
+// deviceTransitions lists the statuses a device may move to from each status.
+var deviceTransitions = map[string][]string{
+	models.DeviceStatusOrdered:        {models.DeviceStatusInStock},
+	models.DeviceStatusInStock:        {models.DeviceStatusActive, models.DeviceStatusDecommissioned},
+	models.DeviceStatusActive:         {models.DeviceStatusInStock, models.DeviceStatusInRepair, models.DeviceStatusDecommissioned},
+	models.DeviceStatusInRepair:       {models.DeviceStatusActive, models.DeviceStatusInStock, models.DeviceStatusDecommissioned},
+	models.DeviceStatusDecommissioned: {models.DeviceStatusDisposed},
+	models.DeviceStatusDisposed:       {},
+}
+
+// reasonRequired holds the target statuses whose transitions must be explained.
+var reasonRequired = map[string]bool{
+	models.DeviceStatusInRepair:       true,
+	models.DeviceStatusDecommissioned: true,
+	models.DeviceStatusDisposed:       true,
+}
+
+// initialDeviceStatuses are the statuses a device may be created with.
+var initialDeviceStatuses = map[string]bool{
+	models.DeviceStatusOrdered: true,
+	models.DeviceStatusInStock: true,
+	models.DeviceStatusActive:  true,
+}
+
+func canTransition(from, to string) bool {
+	for _, status := range deviceTransitions[from] {
+		if status == to {
+			return true
+		}
+	}
+	return false
+}
+
+func newTransition(device *models.Device, to, reason string) (*models.DeviceStatusTransition, error) {
+	reason = strings.TrimSpace(reason)
+	if !canTransition(device.Status, to) {
+		return nil, ErrIllegalTransition
+	}
+	if reasonRequired[to] && reason == "" {
+		return nil, ErrReasonRequired
+	}
+
+	return &models.DeviceStatusTransition{
+		FromStatus: device.Status,
+		ToStatus:   to,
+		Reason:     reason,
+	}, nil
+}
+
+// canDecommission reports whether the device may move to decommissioned,
+// which deleting its network node in cascade mode does.
+func canDecommission(device *models.Device) bool {
+	return canTransition(device.Status, models.DeviceStatusDecommissioned)
+}
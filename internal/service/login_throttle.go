@@ -0,0 +1,141 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrTooManyAttempts = apperror.New(apperror.TooManyRequests, "too many failed login attempts, try again later")
+
+// ThrottledError is returned by Login, and by other checks of a password,
+// while attempts for the login or from the client are delayed or locked
+// out.
+type ThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *ThrottledError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// RetryIn tells the client when to try again.
+func (e *ThrottledError) RetryIn() time.Duration {
+	return e.RetryAfter
+}
+
+func (e *ThrottledError) Unwrap() error {
+	return ErrTooManyAttempts
+}
+
+// loginFailureWindow is how long a failed login is remembered.
+const loginFailureWindow = 15 * time.Minute
+
+// throttlePolicy limits the failed logins counted under one key.
+type throttlePolicy struct {
+	// freeFailures is the number of failures after which each further
+	// attempt has to wait, twice as long as the one before.
+	freeFailures int
+	maxDelay     time.Duration
+	// maxFailures is the number of failures that locks the key out.
+	maxFailures int
+	lockout     time.Duration
+}
+
+var (
+	loginPolicy = throttlePolicy{freeFailures: 3, maxDelay: 30 * time.Second, maxFailures: 5, lockout: 15 * time.Minute}
+	ipPolicy    = throttlePolicy{freeFailures: 10, maxDelay: 30 * time.Second, maxFailures: 50, lockout: 15 * time.Minute}
+)
+
+// delay returns how long after the last of failures the next attempt has
+// to wait.
+func (p throttlePolicy) delay(failures int) time.Duration {
+	if failures <= p.freeFailures {
+		return 0
+	}
+	delay := p.maxDelay
+	if shift := failures - p.freeFailures - 1; shift < 16 {
+		delay = min(time.Second<<shift, p.maxDelay)
+	}
+	return delay
+}
+
+// retryAfter returns how long attempts counted under throttle have to wait;
+// zero means an attempt may be made now.
+func (p throttlePolicy) retryAfter(throttle *models.LoginThrottle, now time.Time) time.Duration {
+	if throttle.LockedUntil != nil && now.Before(*throttle.LockedUntil) {
+		return throttle.LockedUntil.Sub(now)
+	}
+	if now.Sub(throttle.LastFailureAt) > loginFailureWindow {
+		return 0
+	}
+	return max(throttle.LastFailureAt.Add(p.delay(throttle.Failures)).Sub(now), 0)
+}
+
+func loginThrottleKey(login string) string {
+	return "login:" + strings.ToLower(strings.TrimSpace(login))
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// throttleKeys returns the keys of policies.
+func throttleKeys(policies map[string]throttlePolicy) []string {
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// throttlePolicies maps the keys under which a login attempt is counted to
+// the policy applied to each of them.
+func throttlePolicies(login string, client ClientInfo) map[string]throttlePolicy {
+	return map[string]throttlePolicy{
+		loginThrottleKey(login):  loginPolicy,
+		ipThrottleKey(client.IP): ipPolicy,
+	}
+}
+
+// reserveAttempt counts an attempt as failed under every key of policies
+// before its outcome is known, and refuses it while any of the keys is
+// delayed or locked out. An attempt that does not fail is taken back with
+// releaseAttempt. It returns the counters including the attempt.
+func reserveAttempt(attempts *repository.LoginAttemptRepository, policies map[string]throttlePolicy) ([]models.LoginThrottle, error) {
+	now := time.Now()
+	return attempts.ReserveAttempt(throttleKeys(policies), now, now.Add(-loginFailureWindow), func(throttles []models.LoginThrottle) error {
+		var wait time.Duration
+		for i := range throttles {
+			wait = max(wait, policies[throttles[i].Key].retryAfter(&throttles[i], now))
+		}
+		if wait > 0 {
+			return &ThrottledError{RetryAfter: wait}
+		}
+		return nil
+	})
+}
+
+// releaseAttempt takes back an attempt reserved by reserveAttempt that did
+// not fail.
+func releaseAttempt(attempts *repository.LoginAttemptRepository, policies map[string]throttlePolicy) error {
+	return attempts.ReleaseAttempt(throttleKeys(policies)...)
+}
+
+// lockOut locks the keys whose counters, as reserveAttempt counted a
+// failed attempt, reached the limit of their policy.
+func lockOut(attempts *repository.LoginAttemptRepository, policies map[string]throttlePolicy, counted []models.LoginThrottle) error {
+	now := time.Now()
+	for _, throttle := range counted {
+		policy := policies[throttle.Key]
+		if throttle.Failures >= policy.maxFailures && throttle.LockedUntil == nil {
+			if err := attempts.Lock(throttle.Key, now.Add(policy.lockout)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/auth"
+	"equipment-management/pkg/totp"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMFAAlreadyEnabled = apperror.New(apperror.Conflict, "two-factor authentication is already enabled")
+	ErrMFANotEnrolled    = apperror.New(apperror.Conflict, "two-factor authentication is not set up")
+	ErrMFARequired       = apperror.New(apperror.Forbidden, "two-factor authentication is mandatory for your role")
+	ErrInvalidMFACode    = apperror.New(apperror.Unauthorized, "invalid two-factor code")
+)
+
+// TOTPIssuer names the application in authenticator apps.
+const TOTPIssuer = "Equipment Management"
+
+const recoveryCodeCount = 10
+
+// TOTPEnrollment is the secret of an authenticator app being set up.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAStatus describes the second factor of a user.
+type MFAStatus struct {
+	Enabled           bool
+	Required          bool
+	RecoveryCodesLeft int64
+}
+
+type MFAService struct {
+	users    *repository.UserRepository
+	codes    *repository.RecoveryCodeRepository
+	sessions *repository.SessionRepository
+	roles    *RoleService
+}
+
+func NewMFAService(users *repository.UserRepository, codes *repository.RecoveryCodeRepository, sessions *repository.SessionRepository, roles *RoleService) *MFAService {
+	return &MFAService{users: users, codes: codes, sessions: sessions, roles: roles}
+}
+
+func (s *MFAService) GetStatus(userID uint) (*MFAStatus, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	required, err := s.Required(user)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MFAStatus{Enabled: user.TOTPEnabled, Required: required}
+	if user.TOTPEnabled {
+		if status.RecoveryCodesLeft, err = s.codes.CountUnused(userID); err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// Required reports whether the role of the user makes a second factor
+// mandatory.
+func (s *MFAService) Required(user *models.User) (bool, error) {
+	return s.roles.RequiresMFA(user.Role)
+}
+
+// StartEnrollment generates a new TOTP secret for the user. It takes effect
+// once confirmed with ConfirmEnrollment.
+func (s *MFAService) StartEnrollment(userID uint) (*TOTPEnrollment, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.Update(user, map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{Secret: secret, URI: totp.URI(TOTPIssuer, user.Login, secret)}, nil
+}
+
+// ConfirmEnrollment enables the secret generated by StartEnrollment once
+// the user proves to have it with a code, and returns fresh recovery codes.
+func (s *MFAService) ConfirmEnrollment(userID uint, code string) ([]string, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolled
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	if err := s.users.Update(user, map[string]interface{}{"totp_enabled": true}); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(userID)
+}
+
+// Disable turns the second factor off after checking a current code. Users
+// whose role requires a second factor cannot turn it off.
+func (s *MFAService) Disable(userID uint, code string) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	required, err := s.Required(user)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+	if err := s.Verify(user, code); err != nil {
+		return err
+	}
+	return s.clear(user)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user after
+// checking a current code.
+func (s *MFAService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.Verify(user, code); err != nil {
+		return nil, err
+	}
+	return s.newRecoveryCodes(userID)
+}
+
+// Reset removes the second factor of a user who lost it and ends the
+// user's sessions.
+func (s *MFAService) Reset(userID uint) error {
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		return err
+	}
+	if err := s.clear(user); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForUser(userID)
+}
+
+// Verify accepts a TOTP code of the enabled authenticator or an unused
+// recovery code, which is used up.
+func (s *MFAService) Verify(user *models.User, code string) error {
+	if !user.TOTPEnabled {
+		return ErrMFANotEnrolled
+	}
+	if err := s.verifyTOTP(user, code); !errors.Is(err, ErrInvalidMFACode) {
+		return err
+	}
+
+	used, err := s.codes.Use(user.ID, auth.HashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) verifyTOTP(user *models.User, code string) error {
+	step, ok := totp.Validate(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	// Advancing the step fails when the same code was accepted concurrently.
+	advanced, err := s.users.AdvanceTOTPStep(user.ID, step)
+	if err != nil {
+		return err
+	}
+	if !advanced {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func (s *MFAService) newRecoveryCodes(userID uint) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := auth.GenerateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		hashes[i] = auth.HashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := s.codes.Replace(userID, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func (s *MFAService) clear(user *models.User) error {
+	if err := s.users.Update(user, map[string]interface{}{
+		"totp_secret":    "",
+		"totp_enabled":   false,
+		"totp_last_step": 0,
+	}); err != nil {
+		return err
+	}
+	return s.codes.DeleteForUser(user.ID)
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(code))
+}
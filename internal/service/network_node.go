@@ -1,13 +1,21 @@
 package service
 
 import (
+	"equipment-management/internal/apperror"
 	"equipment-management/internal/dto"
 	"equipment-management/internal/models"
 	"equipment-management/internal/repository"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+
+	"gorm.io/gorm"
 )
 
+// BreadcrumbSeparator joins node names in a breadcrumb path.
+const BreadcrumbSeparator = " / "
+
 type NetworkNodeService struct {
 	repo *repository.NetworkNodeRepository
 }
@@ -16,37 +24,379 @@ func NewNetworkNodeService(repo *repository.NetworkNodeRepository) *NetworkNodeS
 	return &NetworkNodeService{repo: repo}
 }
 
-func (s *NetworkNodeService) CreateNode(req *dto.CreateNetworkNodeRequest) (*models.NetworkNode, error) {
+func (s *NetworkNodeService) CreateNode(actor repository.Actor, req *dto.CreateNetworkNodeRequest) (*models.NetworkNode, error) {
+	if err := requireNodeInScope(s.repo, actor, req.ParentID); err != nil {
+		return nil, err
+	}
+
 	node := models.NetworkNode{
 		Name:        req.Name,
 		Description: req.Description,
 		ParentID:    req.ParentID,
 	}
-	if err := s.repo.Create(&node); err != nil {
+	if err := s.repo.Create(actor, &node); err != nil {
 		return nil, err
 	}
 	return &node, nil
 }
 
-func (s *NetworkNodeService) GetNode(id uint) (*models.NetworkNode, error) {
+// GetNode returns the node unless it is outside of the actor's scope, in
+// which case it is reported as not found.
+func (s *NetworkNodeService) GetNode(actor repository.Actor, id uint) (*models.NetworkNode, error) {
+	if err := s.checkNode(actor, id); err != nil {
+		return nil, err
+	}
 	return s.repo.GetByID(id)
 }
 
-func (s *NetworkNodeService) UpdateNode(id uint, req *dto.UpdateNetworkNodeRequest) (*models.NetworkNode, error) {
+// UpdateNode replaces the editable fields of the node if it is still at
+// version, which is 0 to overwrite whatever version it is at.
+func (s *NetworkNodeService) UpdateNode(actor repository.Actor, id, version uint, req *dto.UpdateNetworkNodeRequest) (*models.NetworkNode, error) {
+	node, err := s.GetNode(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.replaceNode(actor, node, version, req)
+}
+
+// PatchNode applies a JSON merge patch to the editable fields of the node
+// if it is still at version, which is 0 to patch whatever version it is
+// at. A null parent_id makes the node a root.
+func (s *NetworkNodeService) PatchNode(actor repository.Actor, id, version uint, patch []byte) (*models.NetworkNode, error) {
+	node, err := s.GetNode(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	if version != 0 && node.Version != version {
+		return nil, repository.ErrVersionMismatch
+	}
+
+	current := dto.UpdateNetworkNodeRequest{
+		Name:        node.Name,
+		Description: node.Description,
+		ParentID:    node.ParentID,
+	}
+	req, err := applyMergePatch(&current, patch)
+	if err != nil {
+		return nil, err
+	}
+	// The patch was computed against this version, so it must not be
+	// applied over a later one.
+	return s.replaceNode(actor, node, node.Version, req)
+}
+
+func (s *NetworkNodeService) replaceNode(actor repository.Actor, node *models.NetworkNode, version uint, req *dto.UpdateNetworkNodeRequest) (*models.NetworkNode, error) {
+	// The parent of the root of the actor's scope lies outside of it, which
+	// must not keep the actor from editing the root in place.
+	if derefID(req.ParentID) != derefID(node.ParentID) {
+		if err := requireNodeInScope(s.repo, actor, req.ParentID); err != nil {
+			return nil, err
+		}
+	}
+
 	updateData := models.NetworkNode{
 		Name:        req.Name,
 		Description: req.Description,
 		ParentID:    req.ParentID,
 	}
-	return s.repo.Update(id, &updateData)
+	return s.repo.Update(actor, node.ID, version, &updateData)
+}
+
+// ErrMoveScopeRoot is returned when a restricted actor tries to move the
+// root of their own scope.
+var ErrMoveScopeRoot = apperror.New(apperror.Forbidden, "the root of your scope cannot be moved")
+
+// MoveNode attaches the node with its branch to another parent. Restricted
+// actors cannot move the root of their scope, nor move a node out of it.
+func (s *NetworkNodeService) MoveNode(actor repository.Actor, id uint, req *dto.MoveNetworkNodeRequest) (*models.NetworkNode, error) {
+	if err := s.checkNode(actor, id); err != nil {
+		return nil, err
+	}
+	if actor.Scope != nil && *actor.Scope == id {
+		return nil, ErrMoveScopeRoot
+	}
+	if err := requireNodeInScope(s.repo, actor, req.ParentID); err != nil {
+		return nil, err
+	}
+	return s.repo.Move(actor, id, req.ParentID, req.Position)
+}
+
+// CopyNode clones the branch of the node, with its devices unless the
+// request says otherwise, under a parent within the actor's scope. It
+// returns the copy of the node and the copied devices.
+func (s *NetworkNodeService) CopyNode(actor repository.Actor, id uint, req *dto.CopyNetworkNodeRequest) (*models.NetworkNode, []repository.CopiedDevice, error) {
+	if err := s.checkNode(actor, id); err != nil {
+		return nil, nil, err
+	}
+	if err := requireNodeInScope(s.repo, actor, req.ParentID); err != nil {
+		return nil, nil, err
+	}
+	return s.repo.Copy(actor, id, req.ParentID, strings.TrimSpace(req.Name), !req.WithoutDevices)
+}
+
+func (s *NetworkNodeService) ToCopyNetworkNodeResponse(node *models.NetworkNode, devices []repository.CopiedDevice) dto.CopyNetworkNodeResponse {
+	response := dto.CopyNetworkNodeResponse{
+		NetworkNodeResponse: s.ToNetworkNodeResponse(node),
+		CopiedDevices:       make([]dto.CopiedDeviceResponse, len(devices)),
+	}
+	for i, copied := range devices {
+		response.CopiedDevices[i] = dto.CopiedDeviceResponse{
+			ID:            copied.Device.ID,
+			SourceID:      copied.SourceID,
+			Serial:        copied.Device.Serial,
+			Status:        copied.Device.Status,
+			NetworkNodeID: *copied.Device.NetworkNodeID,
+		}
+	}
+	return response
+}
+
+// ReorderNodes sets the order of the children of a parent within the
+// actor's scope. Only unrestricted actors can reorder the roots.
+func (s *NetworkNodeService) ReorderNodes(actor repository.Actor, req *dto.ReorderNetworkNodesRequest) error {
+	if err := requireNodeInScope(s.repo, actor, req.ParentID); err != nil {
+		return err
+	}
+	return s.repo.Reorder(req.ParentID, req.IDs)
+}
+
+// ErrInvalidDeleteMode is returned for a delete mode DeleteNode does not know.
+var ErrInvalidDeleteMode = apperror.New(apperror.BadRequest, "mode must be one of: orphan, reparent, cascade, refuse")
+
+// ParseDeleteMode parses the mode of a node deletion; an empty value is
+// repository.DeleteOrphan.
+func ParseDeleteMode(value string) (repository.DeleteMode, error) {
+	switch mode := repository.DeleteMode(value); mode {
+	case "":
+		return repository.DeleteOrphan, nil
+	case repository.DeleteOrphan, repository.DeleteReparent, repository.DeleteCascade, repository.DeleteRefuse:
+		return mode, nil
+	default:
+		return "", ErrInvalidDeleteMode
+	}
+}
+
+// DeleteNode deletes the node according to mode. The root of the actor's
+// own scope cannot be deleted by the actor. In cascade mode the devices of
+// the branch are decommissioned where their status allows it.
+func (s *NetworkNodeService) DeleteNode(actor repository.Actor, id uint, mode repository.DeleteMode) error {
+	if err := s.checkDeletable(actor, id); err != nil {
+		return err
+	}
+	_, err := s.repo.Delete(actor, id, mode, func(node *models.NetworkNode, device *models.Device) *models.DeviceStatusTransition {
+		if !canDecommission(device) {
+			return nil
+		}
+		return &models.DeviceStatusTransition{
+			FromStatus: device.Status,
+			ToStatus:   models.DeviceStatusDecommissioned,
+			Reason:     fmt.Sprintf("network node %q deleted", node.Name),
+		}
+	})
+	return err
+}
+
+// PreviewDeleteNode reports what DeleteNode would change, without changing
+// anything.
+func (s *NetworkNodeService) PreviewDeleteNode(actor repository.Actor, id uint, mode repository.DeleteMode) (*dto.DeletePreviewResponse, error) {
+	if err := s.checkDeletable(actor, id); err != nil {
+		return nil, err
+	}
+	plan, err := s.repo.PlanDelete(id, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	response := dto.DeletePreviewResponse{
+		Mode:        string(plan.Mode),
+		Allowed:     !plan.Refused,
+		NewParentID: plan.Target,
+		Deleted:     make([]dto.NetworkNodeResponse, len(plan.Deleted)),
+		Moved:       make([]dto.NetworkNodeResponse, len(plan.Moved)),
+		Devices:     make([]dto.DeletePreviewDevice, len(plan.Devices)),
+	}
+	for i := range plan.Deleted {
+		response.Deleted[i] = s.ToNetworkNodeResponse(&plan.Deleted[i])
+	}
+	for i := range plan.Moved {
+		response.Moved[i] = s.ToNetworkNodeResponse(&plan.Moved[i])
+	}
+	for i, device := range plan.Devices {
+		action := "unassign"
+		switch {
+		case mode == repository.DeleteCascade && canDecommission(&device):
+			action = "decommission"
+		case plan.Target != nil:
+			action = "move"
+		}
+		response.Devices[i] = dto.DeletePreviewDevice{
+			ID:     device.ID,
+			Type:   device.Type,
+			Model:  device.Model,
+			Serial: device.Serial,
+			Status: device.Status,
+			Action: action,
+		}
+	}
+	return &response, nil
+}
+
+// ErrDeleteScopeRoot is returned when a restricted actor tries to delete
+// the root of their own scope.
+var ErrDeleteScopeRoot = apperror.New(apperror.Forbidden, "the root of your scope cannot be deleted")
+
+func (s *NetworkNodeService) checkDeletable(actor repository.Actor, id uint) error {
+	if actor.Scope != nil {
+		if *actor.Scope == id {
+			return ErrDeleteScopeRoot
+		}
+		if err := s.checkNode(actor, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetAllNodes returns the nodes within the actor's scope.
+func (s *NetworkNodeService) GetAllNodes(actor repository.Actor) ([]models.NetworkNode, error) {
+	nodes, err := s.repo.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return scopedNodes(actor, nodes), nil
+}
+
+// GetSubtree returns the branch rooted at id, limited to depth levels of
+// child nodes below it; a negative depth returns the whole branch.
+func (s *NetworkNodeService) GetSubtree(actor repository.Actor, id uint, depth int) (*dto.TreeNode, error) {
+	if err := s.checkNode(actor, id); err != nil {
+		return nil, err
+	}
+
+	path, err := s.scopedPath(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	nodes, devices, err := s.repo.GetSubtree(id, depth)
+	if err != nil {
+		return nil, err
+	}
+	// The requested node is the root of the branch even when corrupt data
+	// makes it a descendant of itself; every cycle below it passes through
+	// it, so this also keeps the cycle out of the tree.
+	for i := range nodes {
+		if nodes[i].ID == id {
+			nodes[i].ParentID = nil
+		}
+	}
+
+	tree := s.convertToTree(nodes, devices)
+	// The breadcrumbs start above the branch, at the root of the actor's
+	// scope or of the whole forest.
+	prefix := make([]string, len(path)-1)
+	for i, node := range path[:len(path)-1] {
+		prefix[i] = node.Name
+	}
+	if len(prefix) > 0 {
+		prefixPaths(tree, strings.Join(prefix, BreadcrumbSeparator)+BreadcrumbSeparator)
+	}
+	return &tree[0], nil
 }
 
-func (s *NetworkNodeService) DeleteNode(id uint) error {
-	return s.repo.Delete(id)
+// GetAncestors returns the ancestors of the node, starting from the root
+// or, for a restricted actor, from the root of the actor's scope.
+func (s *NetworkNodeService) GetAncestors(actor repository.Actor, id uint) ([]models.NetworkNode, error) {
+	path, err := s.scopedPath(actor, id)
+	if err != nil {
+		return nil, err
+	}
+	return path[:len(path)-1], nil
 }
 
-func (s *NetworkNodeService) GetAllNodes() ([]models.NetworkNode, error) {
-	return s.repo.GetAll()
+// GetBreadcrumb returns the names of the nodes from the root, or the root
+// of the actor's scope, down to the node, joined by BreadcrumbSeparator.
+func (s *NetworkNodeService) GetBreadcrumb(actor repository.Actor, id uint) (string, error) {
+	path, err := s.scopedPath(actor, id)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, len(path))
+	for i, node := range path {
+		names[i] = node.Name
+	}
+	return strings.Join(names, BreadcrumbSeparator), nil
+}
+
+// scopedPath is GetPath cut off above the root of the actor's scope. Nodes
+// outside of the scope are reported as not found.
+func (s *NetworkNodeService) scopedPath(actor repository.Actor, id uint) ([]models.NetworkNode, error) {
+	path, err := s.repo.GetPath(id)
+	if err != nil {
+		return nil, err
+	}
+	if actor.Scope == nil {
+		return path, nil
+	}
+
+	for i, node := range path {
+		if node.ID == *actor.Scope {
+			return path[i:], nil
+		}
+	}
+	return nil, repository.ErrNodeNotFound
+}
+
+// checkNode reports a node outside of the actor's scope as not found.
+func (s *NetworkNodeService) checkNode(actor repository.Actor, id uint) error {
+	inside, err := nodeInScope(s.repo, actor, &id)
+	if err != nil {
+		return err
+	}
+	if !inside {
+		return repository.ErrNodeNotFound
+	}
+	return nil
+}
+
+// BuildBreadcrumbs computes the breadcrumb of every node in nodes, which is
+// expected to contain all of their ancestors as well.
+func (s *NetworkNodeService) BuildBreadcrumbs(nodes []models.NetworkNode) map[uint]string {
+	return buildBreadcrumbs(nodes)
+}
+
+func buildBreadcrumbs(nodes []models.NetworkNode) map[uint]string {
+	byID := make(map[uint]*models.NetworkNode, len(nodes))
+	for i := range nodes {
+		byID[nodes[i].ID] = &nodes[i]
+	}
+
+	paths := make(map[uint]string, len(nodes))
+	var resolve func(node *models.NetworkNode, depth int) string
+	resolve = func(node *models.NetworkNode, depth int) string {
+		if path, ok := paths[node.ID]; ok {
+			return path
+		}
+
+		path := node.Name
+		if parent, ok := byID[derefID(node.ParentID)]; ok && depth < len(nodes) {
+			path = resolve(parent, depth+1) + BreadcrumbSeparator + node.Name
+		}
+		paths[node.ID] = path
+		return path
+	}
+
+	for i := range nodes {
+		resolve(&nodes[i], 0)
+	}
+	return paths
+}
+
+func derefID(id *uint) uint {
+	if id == nil {
+		return 0
+	}
+	return *id
 }
 
 func (s *NetworkNodeService) ToNetworkNodeResponse(node *models.NetworkNode) dto.NetworkNodeResponse {
@@ -55,47 +405,104 @@ func (s *NetworkNodeService) ToNetworkNodeResponse(node *models.NetworkNode) dto
 		Name:        node.Name,
 		Description: node.Description,
 		ParentID:    node.ParentID,
+		Position:    node.Position,
+		Version:     node.Version,
 		CreatedAt:   node.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:   node.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
-func (s *NetworkNodeService) GetFullTree() ([]dto.TreeNode, error) {
-	nodes, err := s.repo.GetFullTree()
+// GetFullTree returns the forest of all nodes or, for a restricted actor,
+// the single tree of the actor's scope.
+func (s *NetworkNodeService) GetFullTree(actor repository.Actor) ([]dto.TreeNode, error) {
+	var nodes []models.NetworkNode
+	var devices []models.Device
+	var err error
+	if actor.Scope != nil {
+		nodes, devices, err = s.repo.GetSubtree(*actor.Scope, -1)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return []dto.TreeNode{}, nil
+		}
+	} else {
+		nodes, devices, err = s.repo.GetFullTree()
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	return s.convertToTree(nodes), nil
+	return s.convertToTree(nodes, devices), nil
 }
 
-func (s *NetworkNodeService) convertToTree(nodes []models.NetworkNode) []dto.TreeNode {
-	result := make([]dto.TreeNode, 0, len(nodes))
-
+// convertToTree links flat node and device lists into the forest of
+// dto.TreeNode, keeping the order of both lists. Within a node its child
+// nodes come first, then its devices. The breadcrumbs of the nodes start at
+// the roots of the forest.
+func (s *NetworkNodeService) convertToTree(nodes []models.NetworkNode, devices []models.Device) []dto.TreeNode {
+	known := make(map[uint]bool, len(nodes))
 	for _, node := range nodes {
-		treeNode := dto.TreeNode{
-			ID:          node.ID,
-			Name:        node.Name,
-			Description: node.Description,
-			Type:        "node",
-			Children:    make([]dto.TreeNode, 0),
+		known[node.ID] = true
+	}
+
+	childrenOf := make(map[uint][]int, len(nodes))
+	roots := make([]int, 0)
+	for i, node := range nodes {
+		if node.ParentID == nil || !known[*node.ParentID] {
+			roots = append(roots, i)
+			continue
 		}
+		childrenOf[*node.ParentID] = append(childrenOf[*node.ParentID], i)
+	}
 
-		for _, device := range node.Devices {
-			treeNode.Children = append(treeNode.Children, dto.TreeNode{
-				ID:   device.ID,
-				Name: fmt.Sprintf("%s: %s", device.Type, device.Model),
-				Type: "device",
-			})
+	devicesOf := make(map[uint][]int, len(nodes))
+	for i, device := range devices {
+		if device.NetworkNodeID != nil {
+			devicesOf[*device.NetworkNodeID] = append(devicesOf[*device.NetworkNodeID], i)
 		}
+	}
+
+	var build func(indexes []int, parentPath string) []dto.TreeNode
+	build = func(indexes []int, parentPath string) []dto.TreeNode {
+		result := make([]dto.TreeNode, 0, len(indexes))
+
+		for _, i := range indexes {
+			node := nodes[i]
+			treeNode := dto.TreeNode{
+				ID:          node.ID,
+				Name:        node.Name,
+				Description: node.Description,
+				Path:        parentPath + node.Name,
+				Type:        "node",
+				Children:    make([]dto.TreeNode, 0),
+			}
+
+			if children := childrenOf[node.ID]; len(children) > 0 {
+				treeNode.Children = append(treeNode.Children, build(children, treeNode.Path+BreadcrumbSeparator)...)
+			}
+
+			for _, j := range devicesOf[node.ID] {
+				device := devices[j]
+				treeNode.Children = append(treeNode.Children, dto.TreeNode{
+					ID:   device.ID,
+					Name: fmt.Sprintf("%s: %s", device.Type, device.Model),
+					Type: "device",
+				})
+			}
 
-		if len(node.Children) > 0 {
-			childNodes := s.convertToTree(node.Children)
-			treeNode.Children = append(treeNode.Children, childNodes...)
+			result = append(result, treeNode)
 		}
 
-		result = append(result, treeNode)
+		return result
 	}
 
-	return result
+	return build(roots, "")
+}
+
+// prefixPaths puts prefix in front of the breadcrumbs of the nodes of tree.
+func prefixPaths(tree []dto.TreeNode, prefix string) {
+	for i := range tree {
+		if tree[i].Type == "node" {
+			tree[i].Path = prefix + tree[i].Path
+		}
+		prefixPaths(tree[i].Children, prefix)
+	}
 }
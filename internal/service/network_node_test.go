@@ -0,0 +1,221 @@
+package service
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"equipment-management/internal/dto"
+	"equipment-management/internal/repository"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// treeSizes are the numbers of nodes of the trees GetFullTree is measured
+// with.
+var treeSizes = []int{1, 100, 10000}
+
+// newTreeService returns a NetworkNodeService over a mocked database and
+// the counter of the statements it runs.
+func newTreeService(tb testing.TB) (*NetworkNodeService, sqlmock.Sqlmock, *int64) {
+	tb.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	var statements int64
+	count := func(*gorm.DB) { atomic.AddInt64(&statements, 1) }
+	callbacks := db.Callback()
+	for _, register := range []func(name string, fn func(*gorm.DB)) error{
+		callbacks.Create().After("*").Register,
+		callbacks.Query().After("*").Register,
+		callbacks.Update().After("*").Register,
+		callbacks.Delete().After("*").Register,
+		callbacks.Row().After("*").Register,
+		callbacks.Raw().After("*").Register,
+	} {
+		if err := register("test:count", count); err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	return NewNetworkNodeService(repository.NewNetworkNodeRepository(db)), mock, &statements
+}
+
+// expectTree makes the mocked database return a tree of size nodes with one
+// device each. Node n > 1 is the parent of nodes 4n to 4n+3, and the root
+// of nodes 2 to 7 as well, so that it has six children.
+func expectTree(mock sqlmock.Sqlmock, size int) {
+	now := time.Now()
+	nodes := sqlmock.NewRows([]string{"id", "name", "description", "parent_id", "position", "version", "created_at", "updated_at"})
+	devices := sqlmock.NewRows([]string{"id", "type", "vendor", "model", "serial", "location", "status", "network_node_id", "version", "created_at", "updated_at"})
+	for id := 1; id <= size; id++ {
+		var parent driver.Value
+		if id > 1 {
+			parent = int64(id / 4)
+			if id < 4 {
+				parent = int64(1)
+			}
+		}
+		nodes.AddRow(id, fmt.Sprintf("node %d", id), "", parent, id%4, 1, now, now)
+		devices.AddRow(id, "switch", "", "model", fmt.Sprintf("SN-%d", id), "", "active", id, 1, now, now)
+	}
+	mock.ExpectQuery(`SELECT network_nodes\.\* FROM network_nodes JOIN tree`).WillReturnRows(nodes)
+	mock.ExpectQuery(`SELECT devices\.\* FROM devices JOIN tree`).WillReturnRows(devices)
+}
+
+func TestGetFullTreeQueryCountDoesNotDependOnTreeSize(t *testing.T) {
+	for _, size := range treeSizes {
+		t.Run(fmt.Sprintf("%d nodes", size), func(t *testing.T) {
+			s, mock, statements := newTreeService(t)
+			expectTree(mock, size)
+
+			tree, err := s.GetFullTree(repository.Actor{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(tree) != 1 {
+				t.Fatalf("got %d roots, want 1", len(tree))
+			}
+			if got := countTreeNodes(tree[0].Children) + 1; got != 2*size {
+				t.Fatalf("tree holds %d nodes and devices, want %d", got, 2*size)
+			}
+			if *statements != 2 {
+				t.Fatalf("GetFullTree ran %d statements, want 2", *statements)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func BenchmarkGetFullTree(b *testing.B) {
+	for _, size := range treeSizes {
+		b.Run(fmt.Sprintf("%d nodes", size), func(b *testing.B) {
+			s, mock, statements := newTreeService(b)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				expectTree(mock, size)
+				b.StartTimer()
+
+				if _, err := s.GetFullTree(repository.Actor{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(*statements)/float64(b.N), "statements/op")
+			if *statements != int64(2*b.N) {
+				b.Fatalf("GetFullTree ran %d statements in %d calls, want 2 per call", *statements, b.N)
+			}
+		})
+	}
+}
+
+func countTreeNodes(nodes []dto.TreeNode) int {
+	count := len(nodes)
+	for _, node := range nodes {
+		count += countTreeNodes(node.Children)
+	}
+	return count
+}
+
+func TestGetFullTreeKeepsOrderOfNodesAndDevices(t *testing.T) {
+	s, mock, _ := newTreeService(t)
+	now := time.Now()
+	// The rows come in the order the repository sorts them, which is not
+	// the order of the ids.
+	mock.ExpectQuery(`SELECT network_nodes\.\* FROM network_nodes JOIN tree`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "description", "parent_id", "position", "version", "created_at", "updated_at"}).
+			AddRow(5, "DC", "main", nil, 0, 1, now, now).
+			AddRow(1, "Lab", "", nil, 1, 1, now, now).
+			AddRow(3, "Row B", "", int64(5), 0, 1, now, now).
+			AddRow(2, "Row A", "", int64(5), 1, 1, now, now).
+			AddRow(4, "Rack 1", "", int64(2), 0, 1, now, now))
+	mock.ExpectQuery(`SELECT devices\.\* FROM devices JOIN tree`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "type", "model", "serial", "status", "network_node_id", "version", "created_at", "updated_at"}).
+			AddRow(11, "router", "R1", "SN-11", "active", 5, 1, now, now).
+			AddRow(10, "switch", "S2", "SN-10", "active", 2, 1, now, now).
+			AddRow(9, "switch", "S1", "SN-9", "active", 2, 1, now, now).
+			AddRow(12, "server", "X", "SN-12", "active", nil, 1, now, now))
+
+	tree, err := s.GetFullTree(repository.Actor{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sep := BreadcrumbSeparator
+	want := []dto.TreeNode{
+		{
+			ID: 5, Name: "DC", Description: "main", Path: "DC", Type: "node",
+			Children: []dto.TreeNode{
+				{ID: 3, Name: "Row B", Path: "DC" + sep + "Row B", Type: "node", Children: []dto.TreeNode{}},
+				{
+					ID: 2, Name: "Row A", Path: "DC" + sep + "Row A", Type: "node",
+					Children: []dto.TreeNode{
+						{ID: 4, Name: "Rack 1", Path: "DC" + sep + "Row A" + sep + "Rack 1", Type: "node", Children: []dto.TreeNode{}},
+						{ID: 10, Name: "switch: S2", Type: "device"},
+						{ID: 9, Name: "switch: S1", Type: "device"},
+					},
+				},
+				{ID: 11, Name: "router: R1", Type: "device"},
+			},
+		},
+		{ID: 1, Name: "Lab", Path: "Lab", Type: "node", Children: []dto.TreeNode{}},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Fatalf("got %+v, want %+v", tree, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetSubtreeOfNodeOnParentCycle(t *testing.T) {
+	s, mock, _ := newTreeService(t)
+	now := time.Now()
+	nodeColumns := []string{"id", "name", "description", "parent_id", "position", "version", "created_at", "updated_at"}
+	// Corrupt data: A and B are each other's parent.
+	mock.ExpectQuery(`WITH RECURSIVE ancestors`).WillReturnRows(
+		sqlmock.NewRows(nodeColumns).
+			AddRow(2, "B", "", int64(1), 0, 1, now, now).
+			AddRow(1, "A", "", int64(2), 0, 1, now, now))
+	mock.ExpectQuery(`SELECT network_nodes\.\* FROM network_nodes JOIN tree`).WillReturnRows(
+		sqlmock.NewRows(nodeColumns).
+			AddRow(1, "A", "", int64(2), 0, 1, now, now).
+			AddRow(2, "B", "", int64(1), 0, 1, now, now))
+	mock.ExpectQuery(`SELECT devices\.\* FROM devices JOIN tree`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "type", "model", "serial", "status", "network_node_id", "version", "created_at", "updated_at"}).
+			AddRow(7, "switch", "X", "SN-7", "active", 2, 1, now, now))
+
+	tree, err := s.GetSubtree(repository.Actor{}, 1, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &dto.TreeNode{
+		ID: 1, Name: "A", Path: "B" + BreadcrumbSeparator + "A", Type: "node",
+		Children: []dto.TreeNode{{
+			ID: 2, Name: "B", Path: "B" + BreadcrumbSeparator + "A" + BreadcrumbSeparator + "B", Type: "node",
+			Children: []dto.TreeNode{{ID: 7, Name: "switch: X", Type: "device"}},
+		}},
+	}
+	if !reflect.DeepEqual(tree, want) {
+		t.Fatalf("got %+v, want %+v", tree, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"equipment-management/internal/apperror"
+	"equipment-management/pkg/mergepatch"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+// DecodeRequest decodes the JSON body of a request into req, which has to
+// be a pointer to a dto struct, and validates it against its binding tags.
+// Unlike gin's binding it rejects fields req does not have, so that a typo
+// does not silently clear a field on a full replacement.
+func DecodeRequest(body []byte, req interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(req); err != nil {
+		return apperror.FromBinding("invalid request data", err)
+	}
+	if decoder.More() {
+		return apperror.New(apperror.BadRequest, "unexpected data after the JSON document")
+	}
+
+	if err := binding.Validator.ValidateStruct(req); err != nil {
+		return apperror.FromBinding("invalid request data", err)
+	}
+	return nil
+}
+
+// applyMergePatch applies a JSON merge patch (RFC 7396) to current and
+// decodes the result as a request of the same type, so that the patched
+// object goes through the same checks as a full replacement.
+func applyMergePatch[T any](current *T, patch []byte) (*T, error) {
+	document, err := json.Marshal(current)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := mergepatch.Apply(document, patch)
+	if err != nil {
+		return nil, apperror.Wrap(apperror.BadRequest, "merge patch is not valid JSON", err)
+	}
+
+	var req T
+	if err := DecodeRequest(patched, &req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
@@ -0,0 +1,367 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrUnknownRole       = apperror.New(apperror.Validation, "role does not exist")
+	ErrUnknownPermission = apperror.New(apperror.Validation, "unknown permission")
+	ErrRoleNameTaken     = apperror.New(apperror.Conflict, "role name is already taken")
+	ErrBuiltinRole       = apperror.New(apperror.Conflict, "built-in roles cannot be renamed or deleted, and the admin role cannot be changed")
+	ErrRoleInUse         = apperror.New(apperror.Conflict, "role is assigned to users")
+)
+
+// permissionCacheTTL bounds how long permission changes made by another
+// server instance take to apply here.
+const permissionCacheTTL = 30 * time.Second
+
+// builtinRoles are created on startup. The admin role always holds every
+// permission; the viewer role starts with read access and may be edited.
+var builtinRoles = []struct {
+	name        string
+	description string
+	permissions []string
+}{
+	{models.RoleAdmin, "Полный доступ", models.AllPermissions},
+	{models.RoleViewer, "Только просмотр", []string{models.PermDeviceRead, models.PermNodeRead}},
+}
+
+type RoleService struct {
+	repo *repository.RoleRepository
+
+	mu       sync.RWMutex
+	cache    map[string]map[string]bool
+	mfa      map[string]bool
+	loadedAt time.Time
+}
+
+func NewRoleService(repo *repository.RoleRepository) *RoleService {
+	return &RoleService{repo: repo}
+}
+
+// EnsureBuiltinRoles creates the built-in roles that do not exist yet and
+// grants the admin role any permission it lacks.
+func (s *RoleService) EnsureBuiltinRoles() error {
+	for _, builtin := range builtinRoles {
+		role, err := s.repo.GetByName(builtin.name)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			role = &models.Role{
+				Name:        builtin.name,
+				Description: builtin.description,
+				Builtin:     true,
+				Permissions: toRolePermissions(builtin.permissions),
+			}
+			if err := s.repo.Create(role); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if builtin.name == models.RoleAdmin && len(role.Permissions) != len(models.AllPermissions) {
+			role.Permissions = toRolePermissions(models.AllPermissions)
+			if err := s.repo.SetPermissions(role); err != nil {
+				return err
+			}
+		}
+	}
+
+	s.invalidate()
+	return nil
+}
+
+// HasPermission reports whether the named role grants permission.
+func (s *RoleService) HasPermission(role, permission string) (bool, error) {
+	permissions, err := s.rolePermissions(role)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// Permissions lists the permissions granted by the named role.
+func (s *RoleService) Permissions(role string) ([]string, error) {
+	permissions, err := s.rolePermissions(role)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]string, 0, len(permissions))
+	for _, permission := range models.AllPermissions {
+		if permissions[permission] {
+			result = append(result, permission)
+		}
+	}
+	return result, nil
+}
+
+// RolesWithPermissions lists the roles that grant all of permissions.
+func (s *RoleService) RolesWithPermissions(permissions ...string) ([]string, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var roles []string
+	for role, granted := range s.cache {
+		all := true
+		for _, permission := range permissions {
+			all = all && granted[permission]
+		}
+		if all {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+
+// RequiresMFA reports whether users of the named role must log in with a
+// second factor.
+func (s *RoleService) RequiresMFA(role string) (bool, error) {
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mfa[role], nil
+}
+
+// RoleExists reports whether a role with the given name is defined.
+func (s *RoleService) RoleExists(name string) (bool, error) {
+	if err := s.load(); err != nil {
+		return false, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.cache[name]
+	return ok, nil
+}
+
+func (s *RoleService) GetAllRoles() ([]models.Role, error) {
+	return s.repo.GetAll()
+}
+
+func (s *RoleService) GetRole(id uint) (*models.Role, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *RoleService) CreateRole(req *dto.RoleRequest) (*models.Role, error) {
+	permissions, err := validatePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if err := s.checkNameFree(name, 0); err != nil {
+		return nil, err
+	}
+
+	role := models.Role{
+		Name:        name,
+		Description: req.Description,
+		Permissions: toRolePermissions(permissions),
+	}
+	if err := s.repo.Create(&role); err != nil {
+		return nil, err
+	}
+
+	s.invalidate()
+	return &role, nil
+}
+
+// UpdateRole renames the role and replaces its permissions. The admin role
+// cannot be changed and built-in roles cannot be renamed.
+func (s *RoleService) UpdateRole(id uint, req *dto.RoleRequest) (*models.Role, error) {
+	role, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	permissions, err := validatePermissions(req.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSpace(req.Name)
+	if role.Builtin && (role.Name == models.RoleAdmin || name != role.Name) {
+		return nil, ErrBuiltinRole
+	}
+	if err := s.checkNameFree(name, id); err != nil {
+		return nil, err
+	}
+
+	oldName := role.Name
+	role.Name = name
+	role.Description = req.Description
+	role.Permissions = toRolePermissions(permissions)
+	if err := s.repo.Update(role, oldName); err != nil {
+		return nil, err
+	}
+
+	s.invalidate()
+	return role, nil
+}
+
+// SetRequireMFA makes a second factor mandatory or optional for users of
+// the role. Unlike UpdateRole it applies to the admin role as well.
+func (s *RoleService) SetRequireMFA(id uint, required bool) (*models.Role, error) {
+	role, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetRequireMFA(role, required); err != nil {
+		return nil, err
+	}
+	role.RequireMFA = required
+
+	s.invalidate()
+	return role, nil
+}
+
+// DeleteRole removes a custom role that no user holds.
+func (s *RoleService) DeleteRole(id uint) error {
+	role, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if role.Builtin {
+		return ErrBuiltinRole
+	}
+
+	users, err := s.repo.CountUsers(role.Name)
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		return ErrRoleInUse
+	}
+
+	if err := s.repo.Delete(id); err != nil {
+		return err
+	}
+
+	s.invalidate()
+	return nil
+}
+
+func (s *RoleService) ToRoleResponse(role *models.Role) dto.RoleResponse {
+	permissions := make([]string, len(role.Permissions))
+	for i, permission := range role.Permissions {
+		permissions[i] = permission.Permission
+	}
+	sort.Strings(permissions)
+
+	return dto.RoleResponse{
+		ID:          role.ID,
+		Name:        role.Name,
+		Description: role.Description,
+		Builtin:     role.Builtin,
+		RequireMFA:  role.RequireMFA,
+		Permissions: permissions,
+	}
+}
+
+func (s *RoleService) checkNameFree(name string, id uint) error {
+	existing, err := s.repo.GetByName(name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != id {
+		return ErrRoleNameTaken
+	}
+	return nil
+}
+
+func (s *RoleService) rolePermissions(role string) (map[string]bool, error) {
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.cache[role], nil
+}
+
+// load refreshes the cache of role permissions when it is stale.
+func (s *RoleService) load() error {
+	s.mu.RLock()
+	fresh := s.cache != nil && time.Since(s.loadedAt) < permissionCacheTTL
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	roles, err := s.repo.GetAll()
+	if err != nil {
+		return err
+	}
+
+	cache := make(map[string]map[string]bool, len(roles))
+	mfa := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		mfa[role.Name] = role.RequireMFA
+		permissions := make(map[string]bool, len(role.Permissions))
+		for _, permission := range role.Permissions {
+			permissions[permission.Permission] = true
+		}
+		cache[role.Name] = permissions
+	}
+
+	s.mu.Lock()
+	s.cache = cache
+	s.mfa = mfa
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *RoleService) invalidate() {
+	s.mu.Lock()
+	s.cache = nil
+	s.mu.Unlock()
+}
+
+func validatePermissions(permissions []string) ([]string, error) {
+	known := make(map[string]bool, len(models.AllPermissions))
+	for _, permission := range models.AllPermissions {
+		known[permission] = true
+	}
+
+	seen := make(map[string]bool, len(permissions))
+	result := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		if !known[permission] {
+			return nil, ErrUnknownPermission
+		}
+		if !seen[permission] {
+			seen[permission] = true
+			result = append(result, permission)
+		}
+	}
+	return result, nil
+}
+
+func toRolePermissions(permissions []string) []models.RolePermission {
+	result := make([]models.RolePermission, len(permissions))
+	for i, permission := range permissions {
+		result[i] = models.RolePermission{Permission: permission}
+	}
+	return result
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+)
+
+// ErrOutOfScope is returned when a change would place an object outside of
+// the subtree the actor is restricted to.
+var ErrOutOfScope = apperror.New(apperror.Forbidden, "network node is outside of your scope")
+
+// ErrUnknownNetworkNode is returned when an object is to be assigned to a
+// network node that does not exist.
+var ErrUnknownNetworkNode = apperror.Invalid("network node does not exist",
+	apperror.FieldError{Field: "network_node_id", Message: "does not exist"})
+
+// nodeInScope reports whether the node with the given id lies within the
+// scope of the actor. Unassigned objects are outside of every scope.
+func nodeInScope(nodes *repository.NetworkNodeRepository, actor repository.Actor, id *uint) (bool, error) {
+	if actor.Scope == nil {
+		return true, nil
+	}
+	if id == nil {
+		return false, nil
+	}
+	return nodes.InSubtree(*actor.Scope, *id)
+}
+
+// requireNodeInScope is nodeInScope for the target of a change: it fails
+// with ErrOutOfScope when the node is outside of the scope.
+func requireNodeInScope(nodes *repository.NetworkNodeRepository, actor repository.Actor, id *uint) error {
+	inside, err := nodeInScope(nodes, actor, id)
+	if err != nil {
+		return err
+	}
+	if !inside {
+		return ErrOutOfScope
+	}
+	return nil
+}
+
+// requireUsableNode checks the node an object is to be assigned to: it has
+// to exist and lie within the scope of the actor. A nil id is refused only
+// for restricted actors, like by requireNodeInScope. For restricted actors
+// a missing node is reported as out of scope, so that they cannot probe for
+// nodes outside of it.
+func requireUsableNode(nodes *repository.NetworkNodeRepository, actor repository.Actor, id *uint) error {
+	if err := requireNodeInScope(nodes, actor, id); err != nil {
+		return err
+	}
+	// Within a scope, InSubtree has already found the node.
+	if id == nil || actor.Scope != nil {
+		return nil
+	}
+
+	exists, err := nodes.Exists(*id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownNetworkNode
+	}
+	return nil
+}
+
+// scopedNodes keeps those of nodes that lie within the scope of the actor,
+// preserving their order.
+func scopedNodes(actor repository.Actor, nodes []models.NetworkNode) []models.NetworkNode {
+	if actor.Scope == nil {
+		return nodes
+	}
+
+	childrenOf := make(map[uint][]uint, len(nodes))
+	exists := false
+	for _, node := range nodes {
+		if node.ParentID != nil {
+			childrenOf[*node.ParentID] = append(childrenOf[*node.ParentID], node.ID)
+		}
+		exists = exists || node.ID == *actor.Scope
+	}
+	if !exists {
+		return nil
+	}
+
+	inside := map[uint]bool{*actor.Scope: true}
+	queue := []uint{*actor.Scope}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		for _, child := range childrenOf[id] {
+			if !inside[child] {
+				inside[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+
+	result := make([]models.NetworkNode, 0, len(inside))
+	for _, node := range nodes {
+		if inside[node.ID] {
+			result = append(result, node)
+		}
+	}
+	return result
+}
@@ -0,0 +1,268 @@
+package service
+
+import (
+	"crypto/subtle"
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/oidc"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrSSODisabled                 = apperror.New(apperror.NotFound, "single sign-on is not configured")
+	ErrInvalidSSOState             = apperror.New(apperror.BadRequest, "invalid or expired single sign-on state")
+	ErrSSOFailed                   = apperror.New(apperror.Unauthorized, "single sign-on failed")
+	ErrIdentityProviderUnavailable = apperror.New(apperror.Unavailable, "identity provider is unavailable, try again later")
+	ErrAccountConflict             = apperror.New(apperror.Conflict, "login belongs to another account")
+)
+
+// SSOStateExpiration is how long the user has to log in at the provider.
+const SSOStateExpiration = 10 * time.Minute
+
+// SSOClaims names the ID token claims that users are mapped from.
+type SSOClaims struct {
+	// Login is the claim shown as the login, e.g. preferred_username.
+	// Users are identified by the issuer and the subject of the ID token
+	// instead, since the provider may let users change this claim.
+	Login string
+	// Groups is the claim listing the groups of the user.
+	Groups string
+}
+
+// ssoStateStore is the part of repository.SSOStateRepository that
+// SSOService needs.
+type ssoStateStore interface {
+	Create(state *models.SSOState) error
+	Take(state string) (*models.SSOState, error)
+	DeleteExpired(now time.Time) error
+}
+
+// ssoUserStore is the part of repository.UserRepository that SSOService
+// needs.
+type ssoUserStore interface {
+	userStore
+	GetByOIDCSubject(issuer, subject string) (*models.User, error)
+}
+
+// loginCompleter is the part of AuthService that finishes the logins of
+// SSOService.
+type loginCompleter interface {
+	completeLogin(user *models.User, client ClientInfo) (*TokenPair, *MFAChallenge, error)
+	recordAttempt(login string, userID *uint, client ClientInfo, reason string) error
+}
+
+// SSOService logs users in through an OpenID Connect provider with the
+// authorization code flow. Like directory users, SSO users are created on
+// their first login and their role follows their groups.
+type SSOService struct {
+	provider *oidc.Provider
+	states   ssoStateStore
+	users    ssoUserStore
+	auth     loginCompleter
+	mapper   groupRoleMapper
+	claims   SSOClaims
+}
+
+// NewSSOService returns the service; a nil provider leaves single sign-on
+// disabled.
+func NewSSOService(provider *oidc.Provider, states *repository.SSOStateRepository, users *repository.UserRepository, auth *AuthService, roles *RoleService, claims SSOClaims, groupRoles []GroupRole, defaultRole string) *SSOService {
+	return &SSOService{
+		provider: provider,
+		states:   states,
+		users:    users,
+		auth:     auth,
+		mapper:   groupRoleMapper{roles: roles, groupRoles: groupRoles, defaultRole: defaultRole},
+		claims:   claims,
+	}
+}
+
+// Start begins a login and returns the URL of the provider to send the user
+// to, together with the state. The provider sends the user back with a code
+// and the state, which Complete only accepts from the browser that started
+// the login; the caller has to keep the state in that browser for it.
+func (s *SSOService) Start() (string, string, error) {
+	if s.provider == nil {
+		return "", "", ErrSSODisabled
+	}
+
+	var state models.SSOState
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		token, err := oidc.RandomToken()
+		if err != nil {
+			return "", "", err
+		}
+		*value = token
+	}
+
+	url, err := s.provider.AuthCodeURL(state.State, state.Nonce, state.CodeVerifier)
+	if err != nil {
+		return "", "", s.providerError(err)
+	}
+
+	now := time.Now()
+	if err := s.states.DeleteExpired(now); err != nil {
+		return "", "", err
+	}
+	state.ExpiresAt = now.Add(SSOStateExpiration)
+	if err := s.states.Create(&state); err != nil {
+		return "", "", err
+	}
+	return url, state.State, nil
+}
+
+// Complete redeems the code the provider sent the user back with and logs
+// the user in like AuthService.Login, including the second factor.
+// browserState is the state kept by the browser the callback comes from,
+// which has to be the one the login was started in; otherwise a user could
+// be logged in with a code obtained by someone else.
+func (s *SSOService) Complete(code, stateValue, browserState string, client ClientInfo) (*TokenPair, *MFAChallenge, error) {
+	if s.provider == nil {
+		return nil, nil, ErrSSODisabled
+	}
+	if browserState == "" || subtle.ConstantTimeCompare([]byte(stateValue), []byte(browserState)) != 1 {
+		return nil, nil, ErrInvalidSSOState
+	}
+
+	state, err := s.states.Take(stateValue)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidSSOState
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().After(state.ExpiresAt) {
+		return nil, nil, ErrInvalidSSOState
+	}
+
+	rawIDToken, err := s.provider.Exchange(code, state.CodeVerifier)
+	if err != nil {
+		return nil, nil, s.providerError(err)
+	}
+	claims, err := s.provider.VerifyIDToken(rawIDToken, state.Nonce)
+	if err != nil {
+		return nil, nil, s.providerError(err)
+	}
+
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, nil, fmt.Errorf("%w: ID token has no \"sub\" claim", ErrSSOFailed)
+	}
+	login, _ := claims[s.claims.Login].(string)
+	login = strings.ToLower(strings.TrimSpace(login))
+	if login == "" {
+		return nil, nil, fmt.Errorf("%w: ID token has no %q claim", ErrSSOFailed, s.claims.Login)
+	}
+
+	user, err := s.users.GetByOIDCSubject(issuer, subject)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		user = nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if user == nil {
+		// A new account must not get into an existing user, whatever its
+		// login claim says.
+		taken, err := s.loginTaken(login)
+		if err != nil {
+			return nil, nil, err
+		}
+		if taken {
+			return nil, nil, ErrAccountConflict
+		}
+	}
+
+	role, err := s.mapper.role(stringList(claims[s.claims.Groups]))
+	if errors.Is(err, ErrNoRoleMapping) {
+		if recordErr := s.auth.recordAttempt(login, userIDOf(user), client, models.LoginFailureNoRole); recordErr != nil {
+			return nil, nil, recordErr
+		}
+		return nil, nil, err
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user, err = s.syncUser(user, issuer, subject, login, role); err != nil {
+		return nil, nil, err
+	}
+	return s.auth.completeLogin(user, client)
+}
+
+// syncUser creates the user of the account on its first login, or else
+// brings the role and the login of the existing user up to date. The login
+// follows the claim only while no other user has it.
+func (s *SSOService) syncUser(user *models.User, issuer, subject, login, role string) (*models.User, error) {
+	if user == nil {
+		user = &models.User{
+			Login:       login,
+			Role:        role,
+			AuthSource:  models.AuthSourceOIDC,
+			OIDCIssuer:  &issuer,
+			OIDCSubject: &subject,
+		}
+		if err := s.users.Create(user); err != nil {
+			return nil, err
+		}
+		return user, nil
+	}
+
+	columns := make(map[string]interface{})
+	if user.Role != role {
+		columns["role"] = role
+	}
+	if user.Login != login {
+		taken, err := s.loginTaken(login)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			columns["login"] = login
+		}
+	}
+	if len(columns) > 0 {
+		if err := s.users.Update(user, columns); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *SSOService) loginTaken(login string) (bool, error) {
+	_, err := s.users.GetByLogin(login)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *SSOService) providerError(err error) error {
+	if errors.Is(err, oidc.ErrUnavailable) {
+		return fmt.Errorf("%w: %v", ErrIdentityProviderUnavailable, err)
+	}
+	return fmt.Errorf("%w: %v", ErrSSOFailed, err)
+}
+
+// stringList reads a claim that holds either a list of strings or a single
+// string.
+func stringList(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		list := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
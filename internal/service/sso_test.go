@@ -0,0 +1,266 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"equipment-management/internal/models"
+	"equipment-management/pkg/oidc"
+	"equipment-management/pkg/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// fakeSSOStates keeps the states of started logins in memory.
+type fakeSSOStates map[string]*models.SSOState
+
+func (f fakeSSOStates) Create(state *models.SSOState) error {
+	f[state.State] = state
+	return nil
+}
+
+func (f fakeSSOStates) Take(state string) (*models.SSOState, error) {
+	taken, ok := f[state]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	delete(f, state)
+	return taken, nil
+}
+
+func (f fakeSSOStates) DeleteExpired(now time.Time) error {
+	for key, state := range f {
+		if state.ExpiresAt.Before(now) {
+			delete(f, key)
+		}
+	}
+	return nil
+}
+
+// fakeLogins stands in for AuthService and remembers what it was asked.
+type fakeLogins struct {
+	completed []*models.User
+	failures  []string
+}
+
+func (f *fakeLogins) completeLogin(user *models.User, client ClientInfo) (*TokenPair, *MFAChallenge, error) {
+	f.completed = append(f.completed, user)
+	return &TokenPair{User: user}, nil, nil
+}
+
+func (f *fakeLogins) recordAttempt(login string, userID *uint, client ClientInfo, reason string) error {
+	if reason != "" {
+		f.failures = append(f.failures, reason)
+	}
+	return nil
+}
+
+func newTestSSOService(server *oidctest.Server, users *fakeUsers, roles fakeRoles, defaultRole string) (*SSOService, *fakeLogins) {
+	logins := &fakeLogins{}
+	return &SSOService{
+		provider: server.Provider(),
+		states:   fakeSSOStates{},
+		users:    users,
+		auth:     logins,
+		mapper: groupRoleMapper{
+			roles: roles,
+			groupRoles: []GroupRole{
+				{Group: "net-admins", Role: "admin"},
+				{Group: "staff", Role: "operator"},
+			},
+			defaultRole: defaultRole,
+		},
+		claims: SSOClaims{Login: "preferred_username", Groups: "groups"},
+	}, logins
+}
+
+// ssoLogin runs a login from Start through the provider to Complete.
+func ssoLogin(t *testing.T, s *SSOService, server *oidctest.Server, claims jwt.MapClaims) (*models.User, error) {
+	t.Helper()
+	server.Claims = func(c jwt.MapClaims) {
+		for name, value := range claims {
+			c[name] = value
+		}
+	}
+
+	authURL, state, err := s.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returnedState := server.Authorize(authURL)
+	tokens, _, err := s.Complete(code, returnedState, state, ClientInfo{IP: "192.0.2.1"})
+	if err != nil {
+		return nil, err
+	}
+	return tokens.User, nil
+}
+
+func TestCompleteRequiresStateOfStartingBrowser(t *testing.T) {
+	// The state is checked before anything else is looked at, so neither
+	// the repositories nor a reachable provider are needed.
+	s := &SSOService{provider: oidc.NewProvider(oidc.Config{Issuer: "http://127.0.0.1:0"})}
+
+	for _, browserState := range []string{"", "state-of-another-login"} {
+		_, _, err := s.Complete("code", "state", browserState, ClientInfo{})
+		if !errors.Is(err, ErrInvalidSSOState) {
+			t.Errorf("browser state %q: got %v, want ErrInvalidSSOState", browserState, err)
+		}
+	}
+}
+
+func TestCompleteProvisionsUserByIssuerAndSubject(t *testing.T) {
+	server := oidctest.NewServer(t)
+	users := newFakeUsers()
+	s, logins := newTestSSOService(server, users, fakeRoles{"admin": true, "operator": true}, "")
+
+	user, err := ssoLogin(t, s, server, jwt.MapClaims{"preferred_username": " Alice ", "groups": []string{"staff"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Login != "alice" || user.Role != "operator" || user.AuthSource != models.AuthSourceOIDC {
+		t.Fatalf("got %+v, want the SSO user alice with role operator", user)
+	}
+	if user.OIDCIssuer == nil || *user.OIDCIssuer != server.Issuer() || user.OIDCSubject == nil || *user.OIDCSubject != oidctest.Subject {
+		t.Fatalf("the user is not bound to the account %s at %s", oidctest.Subject, server.Issuer())
+	}
+	if len(logins.completed) != 1 || logins.completed[0] != user {
+		t.Fatal("the login was not completed for the user")
+	}
+
+	// The provider lets the user rename the account; the subject stays.
+	again, err := ssoLogin(t, s, server, jwt.MapClaims{"preferred_username": "alice.smith", "groups": "net-admins"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != user || len(users.users) != 1 {
+		t.Fatal("a second login of the account must find the provisioned user instead of creating another")
+	}
+	if user.Login != "alice.smith" || user.Role != "admin" {
+		t.Fatalf("got %+v, want the login and the role to follow the claims", user)
+	}
+}
+
+func TestCompleteKeepsLoginTakenByAnotherUser(t *testing.T) {
+	server := oidctest.NewServer(t)
+	users := newFakeUsers(&models.User{Login: "bob", Role: "admin", AuthSource: models.AuthSourceLocal})
+	s, _ := newTestSSOService(server, users, fakeRoles{"operator": true}, "operator")
+
+	user, err := ssoLogin(t, s, server, jwt.MapClaims{"preferred_username": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ssoLogin(t, s, server, jwt.MapClaims{"preferred_username": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	if user.Login != "alice" || users.users["bob"].AuthSource != models.AuthSourceLocal {
+		t.Fatalf("the renamed account took the login of another user: %+v", user)
+	}
+}
+
+func TestCompleteRejectsAccountConflicts(t *testing.T) {
+	issuer, subject := "https://another-provider.example", oidctest.Subject
+	tests := []struct {
+		name     string
+		existing *models.User
+	}{
+		{name: "local user", existing: &models.User{Login: "alice", Role: "admin", AuthSource: models.AuthSourceLocal}},
+		{name: "directory user", existing: &models.User{Login: "alice", Role: "admin", AuthSource: models.AuthSourceLDAP}},
+		{name: "SSO user of another account", existing: &models.User{
+			Login:       "alice",
+			Role:        "admin",
+			AuthSource:  models.AuthSourceOIDC,
+			OIDCIssuer:  &issuer,
+			OIDCSubject: &subject,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := oidctest.NewServer(t)
+			users := newFakeUsers(tt.existing)
+			s, logins := newTestSSOService(server, users, fakeRoles{"operator": true}, "operator")
+
+			_, err := ssoLogin(t, s, server, jwt.MapClaims{"preferred_username": "Alice"})
+			if !errors.Is(err, ErrAccountConflict) {
+				t.Fatalf("got %v, want ErrAccountConflict", err)
+			}
+			if len(users.users) != 1 || tt.existing.Role != "admin" || len(logins.completed) != 0 {
+				t.Fatal("a conflicting login must leave the existing user alone")
+			}
+		})
+	}
+}
+
+func TestCompleteMapsGroupsToRoles(t *testing.T) {
+	tests := []struct {
+		name        string
+		groups      interface{}
+		roles       fakeRoles
+		defaultRole string
+		want        string
+		wantErr     error
+	}{
+		{
+			name:   "first mapping wins",
+			groups: []string{"staff", "NET-ADMINS"},
+			roles:  fakeRoles{"admin": true, "operator": true},
+			want:   "admin",
+		},
+		{
+			name:   "single group as a string",
+			groups: "staff",
+			roles:  fakeRoles{"admin": true, "operator": true},
+			want:   "operator",
+		},
+		{
+			name:        "deleted role falls back to the default role",
+			groups:      []string{"net-admins"},
+			roles:       fakeRoles{"viewer": true},
+			defaultRole: "viewer",
+			want:        "viewer",
+		},
+		{
+			name:    "no mapping and no default role",
+			groups:  []string{"contractors"},
+			roles:   fakeRoles{"admin": true, "operator": true},
+			wantErr: ErrNoRoleMapping,
+		},
+		{
+			name:    "no groups claim and no default role",
+			roles:   fakeRoles{"admin": true, "operator": true},
+			wantErr: ErrNoRoleMapping,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := oidctest.NewServer(t)
+			users := newFakeUsers()
+			s, logins := newTestSSOService(server, users, tt.roles, tt.defaultRole)
+
+			claims := jwt.MapClaims{}
+			if tt.groups != nil {
+				claims["groups"] = tt.groups
+			}
+			user, err := ssoLogin(t, s, server, claims)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %v, want %v", err, tt.wantErr)
+				}
+				if len(users.users) != 0 {
+					t.Fatal("a refused login must not create a user")
+				}
+				if len(logins.failures) != 1 || logins.failures[0] != models.LoginFailureNoRole {
+					t.Fatalf("recorded failures %v, want %q", logins.failures, models.LoginFailureNoRole)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if user.Role != tt.want {
+				t.Fatalf("role = %q, want %q", user.Role, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,376 @@
+package service
+
+import (
+	"equipment-management/internal/apperror"
+	"equipment-management/internal/dto"
+	"equipment-management/internal/models"
+	"equipment-management/internal/repository"
+	"equipment-management/pkg/auth"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrLoginTaken       = apperror.New(apperror.Conflict, "login is already taken")
+	ErrLastAdmin        = apperror.New(apperror.Conflict, "the last active user who can manage users and roles cannot be removed")
+	ErrWrongPassword    = apperror.New(apperror.Forbidden, "current password is incorrect")
+	ErrUnknownScopeNode = apperror.New(apperror.Validation, "scope node does not exist")
+	ErrPasswordRequired = apperror.New(apperror.Validation, "password is required")
+	ErrServiceAccount   = apperror.New(apperror.Conflict, "service accounts have no password")
+	ErrExternalAccount  = apperror.New(apperror.Conflict, "password is managed by the directory")
+)
+
+type UserService struct {
+	repo     *repository.UserRepository
+	sessions *repository.SessionRepository
+	nodes    *repository.NetworkNodeRepository
+	attempts *repository.LoginAttemptRepository
+	roles    *RoleService
+}
+
+func NewUserService(repo *repository.UserRepository, sessions *repository.SessionRepository, nodes *repository.NetworkNodeRepository, attempts *repository.LoginAttemptRepository, roles *RoleService) *UserService {
+	return &UserService{repo: repo, sessions: sessions, nodes: nodes, attempts: attempts, roles: roles}
+}
+
+func (s *UserService) CreateUser(req *dto.CreateUserRequest) (*models.User, error) {
+	login := strings.TrimSpace(req.Login)
+	if err := s.checkLoginFree(login, 0); err != nil {
+		return nil, err
+	}
+	if err := s.checkRoleExists(req.Role); err != nil {
+		return nil, err
+	}
+	if err := s.checkScopeNode(req.ScopeNodeID); err != nil {
+		return nil, err
+	}
+
+	// Service accounts get no password hash at all, so that no password
+	// ever matches.
+	var hash string
+	switch {
+	case req.ServiceAccount && req.Password != "":
+		return nil, ErrServiceAccount
+	case !req.ServiceAccount && req.Password == "":
+		return nil, ErrPasswordRequired
+	case !req.ServiceAccount:
+		var err error
+		if hash, err = auth.HashPassword(req.Password); err != nil {
+			return nil, err
+		}
+	}
+
+	user := models.User{
+		Login:          login,
+		Password:       hash,
+		Role:           req.Role,
+		ScopeNodeID:    req.ScopeNodeID,
+		ServiceAccount: req.ServiceAccount,
+		AuthSource:     models.AuthSourceLocal,
+	}
+	if err := s.repo.Create(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (s *UserService) GetUser(id uint) (*models.User, error) {
+	return s.repo.GetByID(id)
+}
+
+func (s *UserService) GetAllUsers() ([]models.User, error) {
+	return s.repo.GetAll()
+}
+
+func (s *UserService) UpdateUser(id uint, req *dto.UpdateUserRequest) (*models.User, error) {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make(map[string]interface{})
+	if login := strings.TrimSpace(req.Login); login != "" && login != user.Login {
+		if err := s.checkLoginFree(login, id); err != nil {
+			return nil, err
+		}
+		columns["login"] = login
+	}
+	if req.Role != "" && req.Role != user.Role {
+		if err := s.checkRoleExists(req.Role); err != nil {
+			return nil, err
+		}
+		manages, err := s.managesAccess(req.Role)
+		if err != nil {
+			return nil, err
+		}
+		if !manages {
+			if err := s.checkNotLastAdmin(user); err != nil {
+				return nil, err
+			}
+		}
+		columns["role"] = req.Role
+	}
+
+	// Access tokens carry the role and the scope, so sessions opened under
+	// the old ones are ended.
+	revokeSessions := columns["role"] != nil
+	if req.ScopeNodeID != nil {
+		var scope *uint
+		if *req.ScopeNodeID != 0 {
+			scope = req.ScopeNodeID
+		}
+		if derefID(scope) != derefID(user.ScopeNodeID) {
+			if err := s.checkScopeNode(scope); err != nil {
+				return nil, err
+			}
+			if user.ScopeNodeID == nil {
+				if err := s.checkNotLastAdmin(user); err != nil {
+					return nil, err
+				}
+			}
+			columns["scope_node_id"] = scope
+			revokeSessions = true
+		}
+	}
+
+	if len(columns) > 0 {
+		if err := s.repo.Update(user, columns); err != nil {
+			return nil, err
+		}
+	}
+	if revokeSessions {
+		if err := s.sessions.RevokeAllForUser(id); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+// SetDisabled enables or disables the user. Disabled users cannot log in.
+func (s *UserService) SetDisabled(id uint, disabled bool) (*models.User, error) {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := s.checkNotLastAdmin(user); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.repo.Update(user, map[string]interface{}{"disabled": disabled}); err != nil {
+		return nil, err
+	}
+	if disabled {
+		if err := s.sessions.RevokeAllForUser(id); err != nil {
+			return nil, err
+		}
+	}
+	return user, nil
+}
+
+func (s *UserService) DeleteUser(id uint) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.checkNotLastAdmin(user); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeAllForUser(id); err != nil {
+		return err
+	}
+	return s.repo.Delete(id)
+}
+
+// RevokeSessions logs the user out everywhere.
+func (s *UserService) RevokeSessions(id uint) error {
+	if _, err := s.repo.GetByID(id); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForUser(id)
+}
+
+// Unlock forgets the failed login attempts of the user, lifting a lockout.
+func (s *UserService) Unlock(id uint) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	return s.attempts.ResetThrottles(loginThrottleKey(user.Login))
+}
+
+// ResetPassword sets a new password chosen by an admin and ends the
+// user's sessions.
+func (s *UserService) ResetPassword(id uint, password string) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := s.setPassword(user, password); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForUser(id)
+}
+
+// ChangePassword sets a new password for the user after checking the
+// current one, and ends the other sessions of the user; sessionID is the
+// one the change is made in. Wrong current passwords count as failed logins
+// of the user and from the client, so that a stolen session cannot be used
+// to guess the password.
+func (s *UserService) ChangePassword(id, sessionID uint, req *dto.ChangePasswordRequest, client ClientInfo) error {
+	user, err := s.repo.GetByID(id)
+	if err != nil {
+		return err
+	}
+	if err := checkOwnPassword(user); err != nil {
+		return err
+	}
+
+	policies := throttlePolicies(user.Login, client)
+	counted, err := reserveAttempt(s.attempts, policies)
+	if err != nil {
+		return err
+	}
+	if !auth.CheckPasswordHash(req.CurrentPassword, user.Password) {
+		if err := lockOut(s.attempts, policies, counted); err != nil {
+			return err
+		}
+		if err := s.attempts.Record(&models.LoginAttempt{
+			Login:     user.Login,
+			UserID:    &user.ID,
+			IP:        client.IP,
+			UserAgent: client.UserAgent,
+			Reason:    models.LoginFailureWrongPassword,
+		}); err != nil {
+			return err
+		}
+		return ErrWrongPassword
+	}
+	if err := releaseAttempt(s.attempts, policies); err != nil {
+		return err
+	}
+
+	if err := s.setPassword(user, req.NewPassword); err != nil {
+		return err
+	}
+	return s.sessions.RevokeOthersForUser(id, sessionID)
+}
+
+func (s *UserService) setPassword(user *models.User, password string) error {
+	if err := checkOwnPassword(user); err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.repo.Update(user, map[string]interface{}{"password": hash})
+}
+
+// checkOwnPassword refuses password changes for users who log in without a
+// local password.
+func checkOwnPassword(user *models.User) error {
+	switch {
+	case user.ServiceAccount:
+		return ErrServiceAccount
+	case user.AuthSource != models.AuthSourceLocal:
+		return ErrExternalAccount
+	}
+	return nil
+}
+
+func (s *UserService) checkLoginFree(login string, id uint) error {
+	existing, err := s.repo.GetByLogin(login)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if existing.ID != id {
+		return ErrLoginTaken
+	}
+	return nil
+}
+
+func (s *UserService) checkRoleExists(role string) error {
+	exists, err := s.roles.RoleExists(role)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownRole
+	}
+	return nil
+}
+
+func (s *UserService) checkScopeNode(id *uint) error {
+	if id == nil {
+		return nil
+	}
+	if _, err := s.nodes.GetByID(*id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUnknownScopeNode
+		}
+		return err
+	}
+	return nil
+}
+
+// adminRoles lists the roles that grant managing both users and roles.
+// Their holders can restore any access, including their own, so at least
+// one of them has to stay able to log in everywhere.
+func (s *UserService) adminRoles() ([]string, error) {
+	return s.roles.RolesWithPermissions(models.PermUserManage, models.PermRoleManage)
+}
+
+// managesAccess reports whether role is one of adminRoles.
+func (s *UserService) managesAccess(role string) (bool, error) {
+	roles, err := s.adminRoles()
+	if err != nil {
+		return false, err
+	}
+	return slices.Contains(roles, role), nil
+}
+
+// checkNotLastAdmin refuses changes that would leave no active user
+// without a scope in one of adminRoles.
+func (s *UserService) checkNotLastAdmin(user *models.User) error {
+	if user.Disabled || user.ScopeNodeID != nil {
+		return nil
+	}
+	roles, err := s.adminRoles()
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(roles, user.Role) {
+		return nil
+	}
+
+	others, err := s.repo.CountActiveAdmins(user.ID, roles)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func (s *UserService) ToUserResponse(user *models.User) dto.UserResponse {
+	return dto.UserResponse{
+		ID:             user.ID,
+		Login:          user.Login,
+		Role:           user.Role,
+		ScopeNodeID:    user.ScopeNodeID,
+		ServiceAccount: user.ServiceAccount,
+		AuthSource:     user.AuthSource,
+		Disabled:       user.Disabled,
+		CreatedAt:      user.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      user.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -1,18 +1,29 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 	"time"
 )
 
 const (
-	TokenExpiration = 24 * time.Hour
+	AccessTokenExpiration  = 15 * time.Minute
+	RefreshTokenExpiration = 30 * 24 * time.Hour
+	MFATokenExpiration     = 5 * time.Minute
 )
 
+// MFATokenType is the "typ" claim of MFA challenge tokens, which only allow
+// to complete a login with a second factor.
+const MFATokenType = "mfa"
+
 func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	bytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 	return string(bytes), err
 }
 
@@ -21,21 +32,59 @@ func CheckPasswordHash(password, hash string) bool {
 	return err == nil
 }
 
-func GenerateJWT(userID uint, role string, secret string) (string, error) {
+// GenerateJWT issues a short-lived access token bound to a login session.
+// scopeNodeID, when set, is carried in the "scope" claim.
+func GenerateJWT(userID uint, role string, scopeNodeID *uint, sessionID uint, keys *KeySet) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"sub":  userID,
 		"role": role,
-		"exp":  time.Now().Add(TokenExpiration).Unix(),
+		"sid":  sessionID,
+		"jti":  jti,
+		"iat":  now.Unix(),
+		"exp":  now.Add(AccessTokenExpiration).Unix(),
 	}
+	if scopeNodeID != nil {
+		claims["scope"] = *scopeNodeID
+	}
+	return keys.sign(claims)
+}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(secret))
+// GenerateMFAToken issues the token of a login that waits for a second
+// factor.
+func GenerateMFAToken(userID uint, keys *KeySet) (string, error) {
+	jti, err := randomString(16)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"typ": MFATokenType,
+		"jti": jti,
+		"iat": now.Unix(),
+		"exp": now.Add(MFATokenExpiration).Unix(),
+	}
+	return keys.sign(claims)
 }
 
-func ParseJWT(tokenString, secret string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
+// ParseJWT verifies a token issued with GenerateJWT or GenerateMFAToken. The
+// token has to be signed with a key of the set, by the method of that key,
+// and carry the issuer and audience of the set.
+func ParseJWT(tokenString string, keys *KeySet) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, keys.verificationKey,
+		jwt.WithValidMethods(keys.methods),
+		jwt.WithIssuer(keys.Issuer),
+		jwt.WithAudience(keys.Audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
 	if err != nil {
 		return nil, err
@@ -47,3 +96,57 @@ func ParseJWT(tokenString, secret string) (jwt.MapClaims, error) {
 
 	return nil, errors.New("invalid token")
 }
+
+// GenerateRefreshToken returns a random opaque refresh token.
+func GenerateRefreshToken() (string, error) {
+	return randomString(32)
+}
+
+// APIKeyPrefix starts every API key, which tells keys apart from JWTs.
+const APIKeyPrefix = "emk_"
+
+// GenerateAPIKey returns a random API key.
+func GenerateAPIKey() (string, error) {
+	key, err := randomString(32)
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + key, nil
+}
+
+// IsAPIKey reports whether token looks like an API key rather than a JWT.
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// GenerateRecoveryCode returns a random one-time code of the form
+// xxxxx-xxxxx that is easy to type.
+func GenerateRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	const alphabet = "abcdefghijklmnopqrstuvwxyz234567"
+	code := make([]byte, 0, 11)
+	for i, b := range buf {
+		if i == 5 {
+			code = append(code, '-')
+		}
+		code = append(code, alphabet[b&31])
+	}
+	return string(code), nil
+}
+
+// HashToken returns the digest under which an opaque token is stored.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomString(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
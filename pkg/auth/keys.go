@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// MinSecretLength is the minimum length of an HMAC secret.
+const MinSecretLength = 32
+
+// weakSecrets are placeholder secrets from examples and old defaults.
+var weakSecrets = map[string]bool{
+	"secret":      true,
+	"your_secret": true,
+	"changeme":    true,
+}
+
+// IsWeakSecret reports whether secret is too short or a known placeholder
+// to sign tokens with.
+func IsWeakSecret(secret string) bool {
+	return len(secret) < MinSecretLength || weakSecrets[secret]
+}
+
+// SigningKey is a key tokens are signed or verified with. Its ID is sent in
+// the "kid" header, and its method is the only one accepted for it.
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private signs tokens; it is nil for keys that only verify them.
+	private interface{}
+	public  interface{}
+}
+
+// NewHMACKey returns an HS256 key. Its ID is derived from the secret.
+func NewHMACKey(secret []byte) *SigningKey {
+	sum := sha256.Sum256(append([]byte("kid:"), secret...))
+	return &SigningKey{
+		ID:      "hs-" + hex.EncodeToString(sum[:8]),
+		Method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}
+}
+
+// ParsePrivateKeyPEM reads an RSA (RS256) or Ed25519 (EdDSA) private key in
+// PEM form.
+func ParsePrivateKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must have at least 2048 bits")
+		}
+		return newAsymmetricKey(jwt.SigningMethodRS256, key, key.Public())
+	case ed25519.PrivateKey:
+		return newAsymmetricKey(jwt.SigningMethodEdDSA, key, key.Public())
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// ParsePublicKeyPEM reads an RSA or Ed25519 public key in PEM form, e.g.
+// of a retired key whose tokens are still to be accepted.
+func ParsePublicKeyPEM(data []byte) (*SigningKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PUBLIC KEY PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return newAsymmetricKey(jwt.SigningMethodRS256, nil, key)
+	case ed25519.PublicKey:
+		return newAsymmetricKey(jwt.SigningMethodEdDSA, nil, key)
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// newAsymmetricKey derives the ID of the key from its public half, so that
+// the same key always gets the same ID.
+func newAsymmetricKey(method jwt.SigningMethod, private crypto.Signer, public crypto.PublicKey) (*SigningKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	key := &SigningKey{
+		ID:     hex.EncodeToString(sum[:8]),
+		Method: method,
+		public: public,
+	}
+	if private != nil {
+		key.private = private
+	}
+	return key, nil
+}
+
+// KeySet signs new tokens with its current key and accepts tokens signed
+// with any of its keys. Rotating a key means making a new key current and
+// keeping the old one in the set until the tokens signed with it expire.
+type KeySet struct {
+	Issuer   string
+	Audience string
+	current  *SigningKey
+	keys     map[string]*SigningKey
+	methods  []string
+}
+
+// NewKeySet returns a key set that signs with current and also accepts
+// tokens signed with the retired keys.
+func NewKeySet(issuer, audience string, current *SigningKey, retired ...*SigningKey) (*KeySet, error) {
+	if current.private == nil {
+		return nil, errors.New("the current key cannot sign")
+	}
+
+	set := &KeySet{Issuer: issuer, Audience: audience, current: current, keys: map[string]*SigningKey{}}
+	seen := map[string]bool{}
+	for _, key := range append([]*SigningKey{current}, retired...) {
+		if _, exists := set.keys[key.ID]; exists {
+			continue
+		}
+		set.keys[key.ID] = key
+		if !seen[key.Method.Alg()] {
+			seen[key.Method.Alg()] = true
+			set.methods = append(set.methods, key.Method.Alg())
+		}
+	}
+	return set, nil
+}
+
+func (s *KeySet) sign(claims jwt.MapClaims) (string, error) {
+	claims["iss"] = s.Issuer
+	claims["aud"] = s.Audience
+
+	token := jwt.NewWithClaims(s.current.Method, claims)
+	token.Header["kid"] = s.current.ID
+	return token.SignedString(s.current.private)
+}
+
+// verificationKey returns the key named by the "kid" header of the token,
+// provided the token is signed with the method of that key.
+func (s *KeySet) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.public, nil
+}
@@ -0,0 +1,145 @@
+// Package directory checks user credentials against an LDAP directory such
+// as Active Directory.
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the login is unknown to the
+	// directory or the password does not match.
+	ErrInvalidCredentials = errors.New("invalid directory credentials")
+	// ErrUnavailable is returned when the directory cannot be reached or
+	// refuses the lookup.
+	ErrUnavailable = errors.New("directory is unavailable")
+)
+
+// Entry is the account of a user in the directory.
+type Entry struct {
+	DN string
+	// Groups lists the distinguished names of the groups of the user.
+	Groups []string
+}
+
+// Directory checks the password of a login. Implementations other than
+// LDAP may stand in for a real directory, e.g. in tests.
+type Directory interface {
+	Authenticate(login, password string) (*Entry, error)
+}
+
+// Config describes how to reach the directory and find users in it.
+type Config struct {
+	// URL is the address of the server, e.g. ldaps://dc.example.com.
+	URL string
+	// StartTLS upgrades a plain ldap:// connection before binding.
+	StartTLS bool
+	// BindDN and BindPassword are the credentials used to look users up.
+	// Both empty means an anonymous lookup.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of a login; %s is replaced with the
+	// escaped login, e.g. "(sAMAccountName=%s)" for Active Directory.
+	UserFilter string
+	// GroupAttribute lists the groups of a user, usually memberOf.
+	GroupAttribute string
+	Timeout        time.Duration
+}
+
+// LDAP is a Directory backed by an LDAP server. Every call opens its own
+// connection.
+type LDAP struct {
+	config Config
+}
+
+func NewLDAP(config Config) *LDAP {
+	if config.UserFilter == "" {
+		config.UserFilter = "(uid=%s)"
+	}
+	if config.GroupAttribute == "" {
+		config.GroupAttribute = "memberOf"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+	return &LDAP{config: config}
+}
+
+// Authenticate looks the login up with the service credentials and then
+// binds as the user found to check the password.
+func (d *LDAP) Authenticate(login, password string) (*Entry, error) {
+	// An empty password would make an unauthenticated bind, which servers
+	// accept for any DN.
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.config.BindDN != "" {
+		if err := conn.Bind(d.config.BindDN, d.config.BindPassword); err != nil {
+			return nil, fmt.Errorf("%w: service bind: %v", ErrUnavailable, err)
+		}
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		d.config.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.config.Timeout.Seconds()), false,
+		strings.ReplaceAll(d.config.UserFilter, "%s", ldap.EscapeFilter(login)),
+		[]string{"dn", d.config.GroupAttribute},
+		nil,
+	))
+	// More entries than the limit of two are reported as sizeLimitExceeded;
+	// like two entries, they make the login ambiguous.
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("%w: search: %v", ErrUnavailable, err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("%w: user bind: %v", ErrUnavailable, err)
+	}
+
+	return &Entry{DN: found.DN, Groups: found.GetAttributeValues(d.config.GroupAttribute)}, nil
+}
+
+func (d *LDAP) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.config.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.config.Timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	conn.SetTimeout(d.config.Timeout)
+
+	if d.config.StartTLS {
+		address, err := url.Parse(d.config.URL)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: address.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: StartTLS: %v", ErrUnavailable, err)
+		}
+	}
+	return conn, nil
+}
@@ -0,0 +1,340 @@
+package directory
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	testBaseDN          = "ou=people,dc=example,dc=com"
+	testServiceDN       = "cn=reader,dc=example,dc=com"
+	testServicePassword = "reader-secret"
+	testGroup           = "cn=admins,ou=groups,dc=example,dc=com"
+)
+
+// testAccount is an entry of testServer.
+type testAccount struct {
+	dn       string
+	uid      string
+	cn       string
+	password string
+	groups   []string
+}
+
+// testServer is an in-process LDAP server that understands just enough of
+// the protocol for LDAP.Authenticate: simple binds and searches with an
+// equality filter on uid or cn.
+type testServer struct {
+	t        *testing.T
+	listener net.Listener
+	accounts []testAccount
+	// bindCodes, when set for a DN, is the result of every bind as it.
+	bindCodes map[string]uint16
+
+	mu       sync.Mutex
+	binds    []string
+	searches []testSearch
+}
+
+// testSearch is a search request as the server received it.
+type testSearch struct {
+	base      string
+	sizeLimit int64
+	filter    string
+}
+
+func newTestServer(t *testing.T, accounts ...testAccount) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{t: t, listener: listener, accounts: accounts, bindCodes: make(map[string]uint16)}
+	t.Cleanup(func() { listener.Close() })
+	go s.serve()
+	return s
+}
+
+func (s *testServer) directory() *LDAP {
+	return NewLDAP(Config{
+		URL:          "ldap://" + s.listener.Addr().String(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePassword,
+		BaseDN:       testBaseDN,
+		Timeout:      5 * time.Second,
+	})
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		request, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		id := request.Children[0].Value.(int64)
+		op := request.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = append(responses, s.bind(op))
+		case ldap.ApplicationSearchRequest:
+			responses = s.search(op)
+		default:
+			// An unbind or anything else ends the connection.
+			return
+		}
+		for _, response := range responses {
+			message := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+			message.AppendChild(response)
+			if _, err := conn.Write(message.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *testServer) bind(op *ber.Packet) *ber.Packet {
+	dn, password := op.Children[1].Data.String(), op.Children[2].Data.String()
+	s.mu.Lock()
+	s.binds = append(s.binds, dn)
+	code, ok := s.bindCodes[dn]
+	s.mu.Unlock()
+
+	if !ok {
+		code = ldap.LDAPResultInvalidCredentials
+		if dn == testServiceDN && password == testServicePassword {
+			code = ldap.LDAPResultSuccess
+		}
+		for _, account := range s.accounts {
+			if dn == account.dn && password == account.password {
+				code = ldap.LDAPResultSuccess
+			}
+		}
+	}
+	return ldapResult(ldap.ApplicationBindResponse, code)
+}
+
+func (s *testServer) search(op *ber.Packet) []*ber.Packet {
+	filter, err := ldap.DecompileFilter(op.Children[6])
+	if err != nil {
+		s.t.Error(err)
+	}
+	request := testSearch{
+		base:      op.Children[0].Data.String(),
+		sizeLimit: op.Children[3].Value.(int64),
+		filter:    filter,
+	}
+	s.mu.Lock()
+	s.searches = append(s.searches, request)
+	s.mu.Unlock()
+
+	var responses []*ber.Packet
+	for _, account := range s.matching(op.Children[6]) {
+		if request.sizeLimit > 0 && int64(len(responses)) == request.sizeLimit {
+			return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded))
+		}
+		entry := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+		entry.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, account.dn, "Object Name"))
+		attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "memberOf", "Type"))
+		values := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, group := range account.groups {
+			values.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, group, "Value"))
+		}
+		attribute.AppendChild(values)
+		attributes.AppendChild(attribute)
+		entry.AppendChild(attributes)
+		responses = append(responses, entry)
+	}
+	return append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+}
+
+// matching returns the accounts an equality filter on uid or cn selects;
+// other filters select nothing.
+func (s *testServer) matching(filter *ber.Packet) []testAccount {
+	if filter.ClassType != ber.ClassContext || filter.Tag != ldap.FilterEqualityMatch {
+		return nil
+	}
+	attribute, value := filter.Children[0].Data.String(), filter.Children[1].Data.String()
+	var matches []testAccount
+	for _, account := range s.accounts {
+		switch {
+		case strings.EqualFold(attribute, "uid") && strings.EqualFold(account.uid, value),
+			strings.EqualFold(attribute, "cn") && strings.EqualFold(account.cn, value):
+			matches = append(matches, account)
+		}
+	}
+	return matches
+}
+
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	result.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, ldap.LDAPResultCodeMap[code], "Diagnostic Message"))
+	return result
+}
+
+func (s *testServer) requests() ([]string, []testSearch) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.binds...), append([]testSearch(nil), s.searches...)
+}
+
+var alice = testAccount{
+	dn:       "uid=alice,ou=people,dc=example,dc=com",
+	uid:      "alice",
+	cn:       "Alice Smith",
+	password: "secret",
+	groups:   []string{testGroup},
+}
+
+func TestAuthenticateBindsAsServiceThenAsUser(t *testing.T) {
+	s := newTestServer(t, alice)
+
+	entry, err := s.directory().Authenticate("alice", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != alice.dn || len(entry.Groups) != 1 || entry.Groups[0] != testGroup {
+		t.Fatalf("got %+v, want the entry of alice with her group", entry)
+	}
+
+	binds, searches := s.requests()
+	if len(binds) != 2 || binds[0] != testServiceDN || binds[1] != alice.dn {
+		t.Fatalf("binds = %q, want the service account and then alice", binds)
+	}
+	if len(searches) != 1 || searches[0].base != testBaseDN || searches[0].filter != "(uid=alice)" {
+		t.Fatalf("searches = %+v, want one for (uid=alice) under %s", searches, testBaseDN)
+	}
+}
+
+func TestAuthenticateEscapesLoginInFilter(t *testing.T) {
+	s := newTestServer(t, alice)
+
+	for _, login := range []string{"*", "alice)(uid=*", `a\lice`} {
+		if _, err := s.directory().Authenticate(login, "secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("login %q: got %v, want ErrInvalidCredentials", login, err)
+		}
+	}
+
+	binds, searches := s.requests()
+	want := []string{`(uid=\2a)`, `(uid=alice\29\28uid=\2a)`, `(uid=a\5clice)`}
+	if len(searches) != len(want) {
+		t.Fatalf("got %d searches, want %d", len(searches), len(want))
+	}
+	for i, search := range searches {
+		if search.filter != want[i] {
+			t.Errorf("filter = %s, want %s", search.filter, want[i])
+		}
+	}
+	for _, dn := range binds {
+		if dn != testServiceDN {
+			t.Fatalf("bound as %s for a login that matches no entry", dn)
+		}
+	}
+}
+
+func TestAuthenticateRefusesAmbiguousLogin(t *testing.T) {
+	// Three entries share the name; the lookup asks for two at most, which
+	// is enough to tell that the login is ambiguous.
+	accounts := []testAccount{alice, alice, alice}
+	for i := range accounts {
+		accounts[i].dn = strings.Replace(alice.dn, "alice", "alice"+string(rune('1'+i)), 1)
+	}
+	s := newTestServer(t, accounts...)
+	d := s.directory()
+	d.config.UserFilter = "(cn=%s)"
+
+	if _, err := d.Authenticate("Alice Smith", "secret"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+
+	binds, searches := s.requests()
+	if len(searches) != 1 || searches[0].sizeLimit != 2 {
+		t.Fatalf("searches = %+v, want one with a size limit of 2", searches)
+	}
+	if len(binds) != 1 {
+		t.Fatalf("binds = %q, want no user bind for an ambiguous login", binds)
+	}
+}
+
+func TestAuthenticateMapsBindErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		password  string
+		bindCodes map[string]uint16
+		want      error
+	}{
+		{name: "wrong password", password: "wrong", want: ErrInvalidCredentials},
+		{name: "empty password", password: "", want: ErrInvalidCredentials},
+		{
+			name:      "user bind refused otherwise",
+			password:  "secret",
+			bindCodes: map[string]uint16{alice.dn: ldap.LDAPResultUnwillingToPerform},
+			want:      ErrUnavailable,
+		},
+		{
+			name:      "busy server",
+			password:  "secret",
+			bindCodes: map[string]uint16{alice.dn: ldap.LDAPResultBusy},
+			want:      ErrUnavailable,
+		},
+		{
+			// Wrong service credentials are a broken configuration, not
+			// a wrong password of the user.
+			name:      "service bind with invalid credentials",
+			password:  "secret",
+			bindCodes: map[string]uint16{testServiceDN: ldap.LDAPResultInvalidCredentials},
+			want:      ErrUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, alice)
+			for dn, code := range tt.bindCodes {
+				s.bindCodes[dn] = code
+			}
+
+			_, err := s.directory().Authenticate("alice", tt.password)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthenticateReportsUnreachableServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	d := NewLDAP(Config{URL: "ldap://" + address, BaseDN: testBaseDN, Timeout: time.Second})
+	if _, err := d.Authenticate("alice", "secret"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("got %v, want ErrUnavailable", err)
+	}
+}
@@ -0,0 +1,50 @@
+// Package mergepatch applies JSON Merge Patch documents (RFC 7396).
+package mergepatch
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// Apply returns target with patch merged into it: members of a patch object
+// replace those of the target, recursively for objects, and null members
+// remove them. A patch that is not an object replaces the whole target.
+func Apply(target, patch []byte) ([]byte, error) {
+	var targetDoc, patchDoc interface{}
+	if len(bytes.TrimSpace(target)) > 0 {
+		if err := decode(target, &targetDoc); err != nil {
+			return nil, err
+		}
+	}
+	if err := decode(patch, &patchDoc); err != nil {
+		return nil, err
+	}
+	return json.Marshal(merge(targetDoc, patchDoc))
+}
+
+func merge(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = make(map[string]interface{}, len(patchObject))
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = merge(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
+
+// decode keeps numbers as written, so that large IDs survive the round trip.
+func decode(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,105 @@
+package oidc_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"testing"
+	"time"
+
+	"equipment-management/pkg/oidc"
+	"equipment-management/pkg/oidc/oidctest"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// login runs the flow of a relying party up to the verified claims.
+func login(t *testing.T, m *oidctest.Server, p *oidc.Provider) (jwt.MapClaims, error) {
+	t.Helper()
+	state, nonce, verifier := mustToken(t), mustToken(t), mustToken(t)
+
+	authURL, err := p.AuthCodeURL(state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, returnedState := m.Authorize(authURL)
+	if returnedState != state {
+		t.Fatalf("state = %q, want %q", returnedState, state)
+	}
+
+	raw, err := p.Exchange(code, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.VerifyIDToken(raw, nonce)
+}
+
+func mustToken(t *testing.T) string {
+	t.Helper()
+	token, err := oidc.RandomToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	m := oidctest.NewServer(t)
+
+	claims, err := login(t, m, m.Provider())
+	if err != nil {
+		t.Fatalf("VerifyIDToken: %v", err)
+	}
+	if claims["sub"] != oidctest.Subject || claims["preferred_username"] != oidctest.Login {
+		t.Fatalf("unexpected claims %v", claims)
+	}
+}
+
+func TestExchangeRejectsWrongCodeVerifier(t *testing.T) {
+	m := oidctest.NewServer(t)
+	p := m.Provider()
+
+	authURL, err := p.AuthCodeURL(mustToken(t), mustToken(t), mustToken(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _ := m.Authorize(authURL)
+
+	if _, err := p.Exchange(code, mustToken(t)); !errors.Is(err, oidc.ErrExchange) {
+		t.Fatalf("Exchange with another verifier: got %v, want oidc.ErrExchange", err)
+	}
+}
+
+func TestVerifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		claims     func(claims jwt.MapClaims)
+		signingKey *rsa.PrivateKey
+	}{
+		{name: "bad signature", signingKey: otherKey},
+		{name: "wrong issuer", claims: func(c jwt.MapClaims) { c["iss"] = "https://evil.example" }},
+		{name: "wrong audience", claims: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "wrong nonce", claims: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "expired", claims: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "foreign authorized party", claims: func(c jwt.MapClaims) {
+			c["aud"] = []string{oidctest.ClientID, "another-client"}
+			c["azp"] = "another-client"
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := oidctest.NewServer(t)
+			m.Claims = tt.claims
+			m.SigningKey = tt.signingKey
+
+			if _, err := login(t, m, m.Provider()); !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Fatalf("got %v, want oidc.ErrInvalidIDToken", err)
+			}
+		})
+	}
+}
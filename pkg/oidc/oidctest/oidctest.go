@@ -0,0 +1,192 @@
+// Package oidctest provides an in-process OpenID Connect provider for tests
+// of relying parties.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"equipment-management/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	// ClientID and RedirectURL are those of the only client the server
+	// knows.
+	ClientID    = "equipment"
+	RedirectURL = "http://localhost:5500/login.html"
+
+	// Subject and Login are the sub and preferred_username claims of the
+	// ID tokens unless Claims changes them.
+	Subject = "user-1"
+	Login   = "Alice"
+
+	keyID = "test-key"
+)
+
+// Server is an in-process OpenID Connect provider. It serves the discovery
+// document, the key set and the token endpoint; authorization codes are
+// handed out by Authorize instead of a login page.
+type Server struct {
+	t      testing.TB
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]pendingCode
+	// Claims, when set, changes the claims of the next ID tokens.
+	Claims func(claims jwt.MapClaims)
+	// SigningKey, when set, signs the ID tokens instead of the published
+	// key.
+	SigningKey *rsa.PrivateKey
+}
+
+type pendingCode struct {
+	challenge string
+	nonce     string
+}
+
+// NewServer starts a provider that is stopped when the test ends.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{t: t, key: key, codes: make(map[string]pendingCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/token", s.token)
+	s.server = httptest.NewServer(mux)
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+// Issuer returns the issuer URL of the provider.
+func (s *Server) Issuer() string {
+	return s.server.URL
+}
+
+// Provider returns a relying party configured for the provider.
+func (s *Server) Provider() *oidc.Provider {
+	return oidc.NewProvider(oidc.Config{Issuer: s.server.URL, ClientID: ClientID, RedirectURL: RedirectURL})
+}
+
+func (s *Server) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 s.server.URL,
+		"authorization_endpoint": s.server.URL + "/authorize",
+		"token_endpoint":         s.server.URL + "/token",
+		"jwks_uri":               s.server.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(s.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(s.key.E)).Bytes()),
+		}},
+	})
+}
+
+// Authorize plays the login at the provider for the authorization URL and
+// returns the code and the state it would redirect back with.
+func (s *Server) Authorize(authURL string) (code, state string) {
+	s.t.Helper()
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	query := parsed.Query()
+	if got := query.Get("code_challenge_method"); got != "S256" {
+		s.t.Fatalf("code_challenge_method = %q, want S256", got)
+	}
+	if got := query.Get("client_id"); got != ClientID {
+		s.t.Fatalf("client_id = %q, want %q", got, ClientID)
+	}
+	if got := query.Get("redirect_uri"); got != RedirectURL {
+		s.t.Fatalf("redirect_uri = %q, want %q", got, RedirectURL)
+	}
+
+	code, err = oidc.RandomToken()
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.mu.Lock()
+	s.codes[code] = pendingCode{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	s.mu.Unlock()
+	return code, query.Get("state")
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	s.mu.Lock()
+	pending, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != pending.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"id_token": s.idToken(pending.nonce)})
+}
+
+func (s *Server) idToken(nonce string) string {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                s.server.URL,
+		"sub":                Subject,
+		"aud":                ClientID,
+		"iat":                now.Unix(),
+		"exp":                now.Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"preferred_username": Login,
+	}
+	key := s.key
+	s.mu.Lock()
+	if s.Claims != nil {
+		s.Claims(claims)
+	}
+	if s.SigningKey != nil {
+		key = s.SigningKey
+	}
+	s.mu.Unlock()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		s.t.Error(err)
+	}
+	return signed
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}